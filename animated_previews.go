@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mediaprobe"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+	"github.com/google/uuid"
+)
+
+const (
+	animatedPreviewLength = 3 * time.Second
+	animatedPreviewWidth  = 320
+)
+
+var previewContentTypes = map[transcode.PreviewFormat]string{
+	transcode.PreviewWebP: "image/webp",
+	transcode.PreviewMP4:  "video/mp4",
+}
+
+func animatedPreviewPrefix(videoID uuid.UUID) string {
+	return fmt.Sprintf("previews/%s/", videoID)
+}
+
+// generateAnimatedPreview cuts a short clip from the middle of source in the
+// configured format, stores it in the video store and returns its key.
+// Earlier clips are left for the caller to remove
+// once the video points at the new one.
+func (cfg *apiConfig) generateAnimatedPreview(ctx context.Context, videoID uuid.UUID, source string, probe mediaprobe.Result) (string, error) {
+	format := cfg.previewFormat
+	contentType, ok := previewContentTypes[format]
+	if !ok {
+		return "", fmt.Errorf("unknown preview format %q", format)
+	}
+
+	length := min(animatedPreviewLength, probe.Duration)
+	start := (probe.Duration - length) / 2
+	if length <= 0 {
+		// Unknown duration, take what the start of the video has to offer.
+		length, start = animatedPreviewLength, 0
+	}
+
+	outDir, err := os.MkdirTemp("", "tubely-preview-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(outDir)
+	output := filepath.Join(outDir, "preview."+string(format))
+
+	err = transcode.AnimatedPreview(ctx, source, output, start, length, animatedPreviewWidth, format)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(output)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	randomID := make([]byte, 32)
+	_, err = rand.Read(randomID)
+	if err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("%s%s.%s", animatedPreviewPrefix(videoID), base64.RawURLEncoding.EncodeToString(randomID), format)
+	err = cfg.videoStore.Put(ctx, key, f, storage.PutOptions{
+		ContentType: contentType,
+		Size:        stat.Size(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
@@ -10,3 +10,7 @@ func (cfg *apiConfig) ensureAssetsDir() error {
 	}
 	return nil
 }
+
+func (cfg *apiConfig) ensureUploadsDir() error {
+	return os.MkdirAll(cfg.uploadsRoot, 0755)
+}
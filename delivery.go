@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// DELIVERY_MODE picks how clients are sent to media: presigned URLs straight
+// from the store, or CloudFront signed URLs through the S3_CF_DISTRO
+// distribution.
+const (
+	deliveryPresign    = "presign"
+	deliveryCloudFront = "cloudfront"
+)
+
+// loadCDNSigner reads the CloudFront key pair, taking the private key from
+// CLOUDFRONT_PRIVATE_KEY or the file at CLOUDFRONT_PRIVATE_KEY_PATH.
+func loadCDNSigner() (*cdn.Signer, error) {
+	privateKey := []byte(os.Getenv("CLOUDFRONT_PRIVATE_KEY"))
+	if keyPath := os.Getenv("CLOUDFRONT_PRIVATE_KEY_PATH"); len(privateKey) == 0 && keyPath != "" {
+		data, err := os.ReadFile(keyPath)
+		if err != nil {
+			return nil, err
+		}
+		privateKey = data
+	}
+	if len(privateKey) == 0 {
+		return nil, fmt.Errorf("CLOUDFRONT_PRIVATE_KEY or CLOUDFRONT_PRIVATE_KEY_PATH must be set")
+	}
+	return cdn.NewSigner(os.Getenv("CLOUDFRONT_KEY_PAIR_ID"), privateKey)
+}
+
+// cdnURL is the distribution URL of a key in the video bucket.
+func (cfg *apiConfig) cdnURL(key string) string {
+	base := cfg.s3CfDistribution
+	if !strings.Contains(base, "://") {
+		base = "https://" + base
+	}
+	return strings.TrimSuffix(base, "/") + "/" + key
+}
+
+// handlerPlaybackCookies sets CloudFront signed cookies covering everything
+// stored for a video, which adaptive streaming needs: players fetch variant
+// playlists and segments relative to the manifest, without any query string.
+// Only signed in users get cookies, lasting as long as a stream URL would
+// for them.
+func (cfg *apiConfig) handlerPlaybackCookies(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Resource  string    `json:"resource"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	if cfg.deliveryMode != deliveryCloudFront {
+		respondWithError(w, http.StatusNotImplemented, "Signed cookies need the cloudfront delivery mode", nil)
+		return
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || video.Status == database.VideoStatusDeleted {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+	if video.Storage == nil {
+		respondWithError(w, http.StatusConflict, "Video has no media yet", nil)
+		return
+	}
+
+	// Everything for a video lives under the MP4's key minus the extension:
+	// landscape/abc.mp4 has its packages at landscape/abc/hls/ and so on.
+	key := video.Storage.Key
+	prefix := strings.TrimSuffix(key, path.Ext(key))
+
+	audience := audienceShared
+	if userID == video.UserID {
+		audience = audienceOwner
+	}
+	expires := time.Now().Add(cfg.urlLifetimes[audience][mediaKindStream])
+	policy := cdn.Policy{
+		Resource: cfg.cdnURL(prefix) + "/*",
+		Expires:  expires,
+	}
+	cookies, err := cfg.cdnSigner.SignedCookies(policy)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign cookies", err)
+		return
+	}
+	for _, cookie := range cookies {
+		cookie.Domain = cfg.cdnCookieDomain
+		cookie.Path = "/" + prefix + "/"
+		cookie.Secure = true
+		cookie.SameSite = http.SameSiteNoneMode
+		http.SetCookie(w, cookie)
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Resource:  policy.Resource,
+		ExpiresAt: expires,
+	})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+)
+
+// envInt reads an optional integer setting, falling back to def when the
+// variable is unset.
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("%s must be an integer: %v", name, err)
+	}
+	return n
+}
+
+func envBool(name string, def bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("%s must be true or false: %v", name, err)
+	}
+	return b
+}
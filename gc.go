@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// gcPrefixes are the parts of the video store this server writes to. Nothing
+// outside them is ever collected, so the bucket can be shared. "other" is
+// where videos went before the aspect buckets were split up.
+var gcPrefixes = []string{
+	string(AspectRatioLandscape) + "/",
+	string(AspectRatioPortrait) + "/",
+	string(AspectRatioSquare) + "/",
+	string(AspectRatioUltrawide) + "/",
+	string(AspectRatioVertical) + "/",
+	"other/",
+	"thumbnails/",
+	"previews/",
+	"raw/",
+	"incoming/",
+}
+
+type gcOptions struct {
+	// GracePeriod protects objects written recently, since uploads store
+	// them before the video row points at them.
+	GracePeriod time.Duration
+	DryRun      bool
+}
+
+type gcReport struct {
+	Scanned      int
+	Unreferenced int
+	// UnreferencedBytes is the size of every unreferenced object, and
+	// ReclaimedBytes the part of it actually deleted.
+	UnreferencedBytes int64
+	Deleted           int
+	ReclaimedBytes    int64
+	Failed            int
+}
+
+// gcReferences is everything the videos table still points at.
+type gcReferences struct {
+	keys     map[string]bool
+	prefixes []string
+	assets   map[string]bool
+}
+
+func (refs gcReferences) has(key string) bool {
+	if refs.keys[key] {
+		return true
+	}
+	for _, prefix := range refs.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg *apiConfig) gcReferences() (gcReferences, error) {
+	refs := gcReferences{
+		keys:   map[string]bool{},
+		assets: map[string]bool{},
+	}
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		return refs, err
+	}
+
+	bucket := cfg.videoStore.Bucket()
+	for _, video := range videos {
+		if video.Storage != nil && video.Storage.Bucket == bucket {
+			key := video.Storage.Key
+			refs.keys[key] = true
+			// Streaming packages and seek previews live next to the MP4.
+			refs.prefixes = append(refs.prefixes, strings.TrimSuffix(key, path.Ext(key))+"/")
+			if video.PreviewKey != nil {
+				refs.keys[*video.PreviewKey] = true
+			}
+		}
+
+		if video.Thumbnail != nil && video.Thumbnail.Bucket == bucket {
+			for _, rendition := range video.Thumbnail.Renditions {
+				refs.keys[video.Thumbnail.Key(rendition)] = true
+			}
+		}
+		if name, ok := cfg.legacyThumbnailFile(video.LegacyThumbnailURL); ok {
+			refs.assets[name] = true
+		}
+		refs.prefixes = append(refs.prefixes, thumbnailCandidatePrefix(video.ID))
+
+		// Uploads waiting to be processed.
+		if video.Status == database.VideoStatusUploading || video.Status == database.VideoStatusProcessing {
+			refs.prefixes = append(refs.prefixes, rawUploadPrefix(video.ID), directUploadPrefix(video.ID))
+		}
+	}
+	return refs, nil
+}
+
+// collectGarbage finds objects in the video store and the assets directory
+// that no video refers to and that are older than the grace period, and
+// deletes them unless this is a dry run.
+func (cfg *apiConfig) collectGarbage(ctx context.Context, opts gcOptions) (gcReport, error) {
+	var report gcReport
+
+	// List before reading the references: an object stored and referenced
+	// in between is then seen as referenced rather than orphaned.
+	objects := []storage.ObjectInfo{}
+	for _, prefix := range gcPrefixes {
+		listed, err := cfg.videoStore.List(ctx, prefix)
+		if err != nil {
+			return report, fmt.Errorf("couldn't list %s: %w", prefix, err)
+		}
+		objects = append(objects, listed...)
+	}
+	assets, err := cfg.listAssets()
+	if err != nil {
+		return report, fmt.Errorf("couldn't list assets: %w", err)
+	}
+
+	refs, err := cfg.gcReferences()
+	if err != nil {
+		return report, fmt.Errorf("couldn't load references: %w", err)
+	}
+
+	cutoff := time.Now().Add(-opts.GracePeriod)
+	collect := func(name string, size int64, modified time.Time, referenced bool, remove func() error) {
+		report.Scanned++
+		if referenced || modified.After(cutoff) {
+			return
+		}
+		report.Unreferenced++
+		report.UnreferencedBytes += size
+		if opts.DryRun {
+			log.Printf("gc: would delete %s (%d bytes, modified %s)", name, size, modified.Format(time.RFC3339))
+			return
+		}
+		if err := remove(); err != nil {
+			report.Failed++
+			log.Printf("gc: couldn't delete %s: %v", name, err)
+			return
+		}
+		report.Deleted++
+		report.ReclaimedBytes += size
+		log.Printf("gc: deleted %s (%d bytes)", name, size)
+	}
+
+	for _, obj := range objects {
+		collect(obj.Key, obj.Size, obj.LastModified, refs.has(obj.Key), func() error {
+			return cfg.videoStore.Delete(ctx, obj.Key)
+		})
+	}
+	for _, asset := range assets {
+		name := asset.Name()
+		info, err := asset.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return report, err
+		}
+		collect(filepath.Join(cfg.assetsRoot, name), info.Size(), info.ModTime(), refs.assets[name], func() error {
+			return cfg.deleteStorageObjects(ctx, database.StorageDeletionAssets, name)
+		})
+	}
+	return report, nil
+}
+
+// listAssets returns the files directly in the assets directory, where
+// thumbnails were written before they moved into the video store.
+func (cfg *apiConfig) listAssets() ([]fs.DirEntry, error) {
+	entries, err := os.ReadDir(cfg.assetsRoot)
+	if err != nil {
+		return nil, err
+	}
+	files := []fs.DirEntry{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		files = append(files, entry)
+	}
+	return files, nil
+}
+
+func (report gcReport) String() string {
+	if report.Deleted == 0 && report.Failed == 0 {
+		return fmt.Sprintf("scanned %d objects, %d unreferenced (%d bytes)",
+			report.Scanned, report.Unreferenced, report.UnreferencedBytes)
+	}
+	return fmt.Sprintf("scanned %d objects, %d unreferenced, deleted %d, reclaimed %d bytes, %d failed",
+		report.Scanned, report.Unreferenced, report.Deleted, report.ReclaimedBytes, report.Failed)
+}
+
+// runGCCommand is the gc subcommand: one collection, then exit.
+func (cfg *apiConfig) runGCCommand(args []string) {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "report unreferenced objects without deleting them")
+	grace := flags.Duration("grace", gcGracePeriod(), "only collect objects older than this")
+	flags.Parse(args)
+
+	report, err := cfg.collectGarbage(context.Background(), gcOptions{
+		GracePeriod: *grace,
+		DryRun:      *dryRun,
+	})
+	if err != nil {
+		log.Fatalf("gc failed: %v", err)
+	}
+	fmt.Println("gc:", report)
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// runPeriodicGC collects garbage every interval until ctx is cancelled.
+func (cfg *apiConfig) runPeriodicGC(ctx context.Context, interval time.Duration, opts gcOptions) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		report, err := cfg.collectGarbage(ctx, opts)
+		if err != nil {
+			log.Printf("gc failed: %v", err)
+			continue
+		}
+		log.Printf("gc: %s", report)
+	}
+}
+
+func gcGracePeriod() time.Duration {
+	return time.Duration(envInt("GC_GRACE_HOURS", 24)) * time.Hour
+}
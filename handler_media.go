@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+func (cfg *apiConfig) handlerMediaGet(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+
+	body, info, err := cfg.videoStore.Get(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			respondWithError(w, http.StatusNotFound, "Media not found", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read media", err)
+		return
+	}
+	defer body.Close()
+
+	if info.ContentType != "" {
+		w.Header().Set("Content-Type", info.ContentType)
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	w.Header().Set("ETag", info.ETag)
+	io.Copy(w, body)
+}
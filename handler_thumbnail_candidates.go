@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerThumbnailCandidatesList(w http.ResponseWriter, r *http.Request) {
+	type candidateResponse struct {
+		database.ThumbnailCandidate
+		URL string `json:"url"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	candidates, err := cfg.db.GetThumbnailCandidates(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail candidates", err)
+		return
+	}
+
+	response := []candidateResponse{}
+	for _, candidate := range candidates {
+		url, err := cfg.resolveListingObject(database.StorageRef{
+			Bucket: cfg.videoStore.Bucket(),
+			Key:    candidate.StorageKey,
+		}, audienceOwner)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't build candidate URL", err)
+			return
+		}
+		response = append(response, candidateResponse{
+			ThumbnailCandidate: candidate,
+			URL:                url,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerThumbnailCandidateSelect(w http.ResponseWriter, r *http.Request) {
+	candidateID, err := uuid.Parse(r.PathValue("candidateID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid candidate ID", err)
+		return
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	candidate, err := cfg.db.GetThumbnailCandidate(candidateID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail candidate", err)
+		return
+	}
+	if candidate.ID == uuid.Nil || candidate.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Thumbnail candidate not found", nil)
+		return
+	}
+
+	body, _, err := cfg.videoStore.Get(r.Context(), candidate.StorageKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read thumbnail candidate", err)
+		return
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read thumbnail candidate", err)
+		return
+	}
+
+	// The chosen frame is copied like an uploaded thumbnail, so replacing
+	// the candidates on a later upload doesn't break it.
+	thumbnail, err := cfg.storeThumbnail(r.Context(), video.ID, data)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store thumbnail", err)
+		return
+	}
+	replaced, err := cfg.db.SetVideoThumbnail(video.ID, thumbnail)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.deleteReplacedThumbnail(r.Context(), replaced)
+	video.Thumbnail = &thumbnail
+	video.LegacyThumbnailURL = nil
+
+	signedVideo, err := cfg.videoToResponse(video, audienceOwner)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, signedVideo)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/validation"
+	"github.com/google/uuid"
+)
+
+const directUploadExpiry = 15 * time.Minute
+
+// Direct uploads land under incoming/{videoID}/ until the processing job has
+// moved them to their final key.
+func directUploadPrefix(videoID uuid.UUID) string {
+	return fmt.Sprintf("incoming/%s/", videoID)
+}
+
+func (cfg *apiConfig) handlerDirectUploadCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ContentType string `json:"content_type"`
+		Size        int64  `json:"size"`
+	}
+	type response struct {
+		UploadURL string            `json:"upload_url"`
+		Method    string            `json:"method"`
+		Headers   map[string]string `json:"headers"`
+		Key       string            `json:"key"`
+		ExpiresAt time.Time         `json:"expires_at"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	mediaType, err := cfg.checkDeclaredType(params.ContentType)
+	if err != nil {
+		respondWithValidationError(w, err)
+		return
+	}
+	if params.Size <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Size is required", nil)
+		return
+	}
+	if err := validation.CheckSize(params.Size, cfg.uploadLimits); err != nil {
+		respondWithValidationError(w, err)
+		return
+	}
+
+	randomID := make([]byte, 32)
+	_, err = rand.Read(randomID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate key", err)
+		return
+	}
+	key := directUploadPrefix(video.ID) + base64.RawURLEncoding.EncodeToString(randomID) + "." + videoContainers[mediaType].ext
+
+	uploadURL, err := cfg.videoStore.PresignPut(r.Context(), key, storage.PutOptions{
+		ContentType: mediaType,
+		Size:        params.Size,
+	}, directUploadExpiry)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotSupported) {
+			respondWithError(w, http.StatusNotImplemented, "Direct uploads are not available with this storage backend", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't presign upload", err)
+		return
+	}
+
+	if !cfg.setVideoStatus(w, video.ID, database.VideoStatusUploading) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		UploadURL: uploadURL,
+		Method:    http.MethodPut,
+		Headers: map[string]string{
+			"Content-Type": mediaType,
+		},
+		Key:       key,
+		ExpiresAt: time.Now().UTC().Add(directUploadExpiry),
+	})
+}
+
+func (cfg *apiConfig) handlerDirectUploadComplete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Key string `json:"key"`
+	}
+
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// Only keys we handed out for this video are accepted, otherwise a caller
+	// could point their video at someone else's object.
+	if !strings.HasPrefix(params.Key, directUploadPrefix(video.ID)) || strings.Contains(params.Key, "..") {
+		respondWithError(w, http.StatusBadRequest, "Invalid key", nil)
+		return
+	}
+
+	info, err := cfg.videoStore.Head(r.Context(), params.Key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			respondWithError(w, http.StatusConflict, "Upload not found, PUT the file first", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check upload", err)
+		return
+	}
+	if info.Size <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Uploaded object is empty", nil)
+		return
+	}
+	mediaType, err := cfg.checkDeclaredType(info.ContentType)
+	if err != nil {
+		respondWithValidationError(w, err)
+		return
+	}
+
+	// The client wrote the object without passing through us, so nothing
+	// about it has been checked yet.
+	tempPath, err := cfg.downloadToTemp(r.Context(), params.Key)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read upload", err)
+		return
+	}
+	defer os.Remove(tempPath)
+	if !cfg.validateVideoUpload(w, r, video, tempPath, mediaType) {
+		if err := cfg.videoStore.Delete(r.Context(), params.Key); err != nil {
+			log.Printf("Couldn't delete rejected upload %s: %v", params.Key, err)
+		}
+		return
+	}
+
+	// The object is already in the store, so it is handed to the worker as
+	// the raw upload and removed once processed.
+	cfg.enqueueVideoProcessing(w, video, params.Key, mediaType)
+}
+
+// getOwnedVideo runs the JWT and ownership checks shared by the upload
+// handlers, writing the error response itself when they fail. Deleted
+// videos are treated as missing.
+func (cfg *apiConfig) getOwnedVideo(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.Video{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Video{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Video{}, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil || video.Status == database.VideoStatusDeleted {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return database.Video{}, false
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "User not authorized", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
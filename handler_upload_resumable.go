@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/validation"
+	"github.com/google/uuid"
+)
+
+// The resumable endpoints follow the tus 1.0 core protocol closely enough for
+// tus clients to work: create an upload, PATCH chunks at the current offset,
+// HEAD to find out where to resume, then finalize to start processing.
+const tusVersion = "1.0.0"
+
+// uploadLocks serializes PATCH requests per upload so two chunks never write
+// to the same partial file at once.
+var uploadLocks sync.Map
+
+func (cfg *apiConfig) handlerResumableUploadCreate(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Length", err)
+		return
+	}
+	if err := validation.CheckSize(length, cfg.uploadLimits); err != nil {
+		respondWithValidationError(w, err)
+		return
+	}
+
+	metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Metadata", err)
+		return
+	}
+	mediaType, err := cfg.checkDeclaredType(metadata["filetype"])
+	if err != nil {
+		respondWithValidationError(w, err)
+		return
+	}
+
+	if !cfg.setVideoStatus(w, video.ID, database.VideoStatusUploading) {
+		return
+	}
+
+	partial, err := os.CreateTemp(cfg.uploadsRoot, "upload-*.part")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload", err)
+		return
+	}
+	partial.Close()
+
+	upload, err := cfg.db.CreateUpload(database.CreateUploadParams{
+		VideoID:   video.ID,
+		UserID:    video.UserID,
+		MediaType: mediaType,
+		Length:    length,
+		Path:      partial.Name(),
+	})
+	if err != nil {
+		os.Remove(partial.Name())
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload", err)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Location", fmt.Sprintf("/api/uploads/%s", upload.ID))
+	respondWithJSON(w, http.StatusCreated, upload)
+}
+
+func (cfg *apiConfig) handlerResumableUploadHead(w http.ResponseWriter, r *http.Request) {
+	upload, ok := cfg.getOwnedUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (cfg *apiConfig) handlerResumableUploadPatch(w http.ResponseWriter, r *http.Request) {
+	upload, ok := cfg.getOwnedUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream", nil)
+		return
+	}
+	if upload.CompletedAt != nil {
+		respondWithError(w, http.StatusConflict, "Upload already completed", nil)
+		return
+	}
+
+	lock, _ := uploadLocks.LoadOrStore(upload.ID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	// Re-read under the lock, a concurrent PATCH may have moved the offset
+	// or the upload may have been completed meanwhile.
+	upload, err := cfg.db.GetUpload(upload.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return
+	}
+	if upload.CompletedAt != nil {
+		respondWithError(w, http.StatusConflict, "Upload already completed", nil)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Offset", err)
+		return
+	}
+	if offset != upload.Offset {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		respondWithError(w, http.StatusConflict, "Upload-Offset does not match current offset", nil)
+		return
+	}
+
+	partial, err := os.OpenFile(upload.Path, os.O_WRONLY, 0600)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open upload", err)
+		return
+	}
+	defer partial.Close()
+
+	// Bytes past the recorded offset come from a chunk whose offset was never
+	// persisted, so they are discarded rather than trusted.
+	if err := partial.Truncate(upload.Offset); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't prepare upload", err)
+		return
+	}
+	if _, err := partial.Seek(upload.Offset, io.SeekStart); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't prepare upload", err)
+		return
+	}
+
+	remaining := upload.Length - upload.Offset
+	written, copyErr := io.Copy(partial, io.LimitReader(r.Body, remaining))
+
+	// Whatever made it to disk is kept even if the connection dropped midway,
+	// that is the whole point of resuming.
+	if err := partial.Sync(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't write upload", err)
+		return
+	}
+	newOffset := upload.Offset + written
+	if err := cfg.db.UpdateUploadOffset(upload.ID, newOffset); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save upload offset", err)
+		return
+	}
+	if copyErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Upload interrupted", copyErr)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerResumableUploadComplete(w http.ResponseWriter, r *http.Request) {
+	upload, ok := cfg.getOwnedUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if upload.Offset != upload.Length {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("Upload incomplete: %d of %d bytes received", upload.Offset, upload.Length), nil)
+		return
+	}
+
+	lock, _ := uploadLocks.LoadOrStore(upload.ID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	// Completing is slow, so clients retry it. Only the call that claims the
+	// upload goes on; a retry finds it claimed and gets a 409 instead of
+	// failing the video over the partial file the first call removed.
+	claimed, err := cfg.db.ClaimUpload(upload.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't complete upload", err)
+		return
+	}
+	if !claimed {
+		respondWithError(w, http.StatusConflict, "Upload already completed", nil)
+		return
+	}
+	// Until the video is touched a failure can be retried.
+	release := func() {
+		if err := cfg.db.ReleaseUpload(upload.ID); err != nil {
+			log.Printf("Couldn't release upload %s: %v", upload.ID, err)
+		}
+	}
+
+	video, err := cfg.db.GetVideo(upload.VideoID)
+	if err != nil {
+		release()
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+
+	if !cfg.validateVideoUpload(w, r, video, upload.Path, upload.MediaType) {
+		os.Remove(upload.Path)
+		uploadLocks.Delete(upload.ID)
+		return
+	}
+
+	assembled, err := os.Open(upload.Path)
+	if err != nil {
+		release()
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open upload", err)
+		return
+	}
+	rawKey, err := cfg.storeRawUpload(r.Context(), video, assembled, upload.MediaType)
+	assembled.Close()
+	if err != nil {
+		release()
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store upload", err)
+		return
+	}
+
+	os.Remove(upload.Path)
+	uploadLocks.Delete(upload.ID)
+
+	cfg.enqueueVideoProcessing(w, video, rawKey, upload.MediaType)
+}
+
+func (cfg *apiConfig) getOwnedUpload(w http.ResponseWriter, r *http.Request) (database.Upload, bool) {
+	uploadID, err := uuid.Parse(r.PathValue("uploadID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid upload ID", err)
+		return database.Upload{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Upload{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Upload{}, false
+	}
+
+	upload, err := cfg.db.GetUpload(uploadID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return database.Upload{}, false
+	}
+	if upload.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Upload not found", nil)
+		return database.Upload{}, false
+	}
+	if upload.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "User not authorized", nil)
+		return database.Upload{}, false
+	}
+	return upload, true
+}
+
+// parseUploadMetadata decodes a tus Upload-Metadata header, a comma separated
+// list of "key base64value" pairs.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(pair)
+		switch len(parts) {
+		case 1:
+			metadata[parts[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("metadata %q: %w", parts[0], err)
+			}
+			metadata[parts[0]] = string(value)
+		default:
+			return nil, errors.New("malformed metadata pair")
+		}
+	}
+	return metadata, nil
+}
@@ -1,142 +1,81 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
-	"mime"
 	"mime/multipart"
 	"net/http"
-	"os"
-	"path/filepath"
-	"strings"
 
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
-	"github.com/google/uuid"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/validation"
 )
 
 func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+	videoInfo, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
+	fmt.Println("uploading thumbnail for video", videoInfo.ID, "by user", videoInfo.UserID)
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
+	// As with videos, the size limit bounds what is read, not just what is
+	// kept.
+	limit := cfg.thumbnailLimits.MaxFileSize
+	if limit > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limit+multipartOverhead)
 	}
 
-	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
-
 	const maxMemory = 10 << 20
-	r.ParseMultipartForm(maxMemory)
-	file, _, err := r.FormFile("thumbnail")
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to parse from file", err)
-		return
-	}
-
-	fileHeader := make([]byte, 512)
-	_, err = file.Read(fileHeader)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to read file header", err)
-		return
-	}
-
-	// Reset the file pointer
-	_, err = file.Seek(0, 0)
+	err := r.ParseMultipartForm(maxMemory)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to seek file", err)
-		return
-	}
-	headerContentType := http.DetectContentType(fileHeader)
-	fmt.Println("Content Type: ", headerContentType)
-
-	//read all the file content into a byte slice
-	fileBytes := make([]byte, 512)
-	var fileContent []byte
-	for {
-		n, err := file.Read(fileBytes)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			respondWithError(w, http.StatusBadRequest, "Unable to read file content", err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondWithValidationError(w, validation.SizeExceeded(cfg.thumbnailLimits))
 			return
 		}
-		fileContent = append(fileContent, fileBytes[:n]...)
-	}
-
-	// reset the file pointer
-	_, err = file.Seek(0, 0)
-
-	videoInfo, err := cfg.db.GetVideo(videoID)
-
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to get video", err)
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form", err)
 		return
 	}
-
-	mimeType, _, err := mime.ParseMediaType(headerContentType)
-
+	file, _, err := r.FormFile("thumbnail")
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to parse media type", err)
-	}
-
-	if mimeType != "image/jpeg" && mimeType != "image/png" {
-		fmt.Printf("Invalid media type: %s", mimeType)
-		respondWithError(w, http.StatusBadRequest, "Invalid media type", err)
+		respondWithError(w, http.StatusBadRequest, "Unable to parse from file", err)
 		return
 	}
 
-	var newThumbnail thumbnail
-	newThumbnail.data = fileContent
-	newThumbnail.mediaType = headerContentType
-
-	var byteArray = make([]byte, 32)
-	_, err = rand.Read(byteArray)
+	// One byte over the limit is enough for ValidateImage to reject it.
+	var content io.Reader = file
+	if limit > 0 {
+		content = io.LimitReader(file, limit+1)
+	}
+	fileContent, err := io.ReadAll(content)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to generate random ID", err)
+		respondWithError(w, http.StatusBadRequest, "Unable to read file content", err)
 		return
 	}
 
-	var stringBase64 = base64.StdEncoding.EncodeToString(byteArray)
-
-	var extensionFile = strings.Split(headerContentType, "/")[1]
-	create, err := os.Create(fmt.Sprintf("%s/%s.%s", filepath.Clean(cfg.assetsRoot), stringBase64, extensionFile))
-
+	// The type comes from the bytes themselves, never the client's header.
+	_, err = validation.ValidateImage(fileContent, allowedThumbnailTypes, cfg.thumbnailLimits)
 	if err != nil {
-		fmt.Println("Error creating file", err)
+		respondWithValidationError(w, err)
 		return
 	}
 
-	written, err := io.Copy(create, file)
+	stored, err := cfg.storeThumbnail(r.Context(), videoInfo.ID, fileContent)
 	if err != nil {
-
-		fmt.Println("Error writing file", err)
+		respondWithError(w, http.StatusInternalServerError, "Unable to store thumbnail", err)
+		return
 	}
 
-	fmt.Println("Written", written, "bytes")
+	fmt.Println("Written", len(fileContent), "bytes")
 
-	var nameVideo = fmt.Sprintf("%s.%s", stringBase64, extensionFile)
-	var dataURL = fmt.Sprintf("http://localhost:%s/%s/%s", cfg.port, filepath.Clean(cfg.assetsRoot), nameVideo)
-
-	videoInfo.ThumbnailURL = &dataURL
-	err = cfg.db.UpdateVideo(videoInfo)
+	replaced, err := cfg.db.SetVideoThumbnail(videoInfo.ID, stored)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Unable to update video", err)
 		return
 	}
+	cfg.deleteReplacedThumbnail(r.Context(), replaced)
+	videoInfo.Thumbnail = &stored
+	videoInfo.LegacyThumbnailURL = nil
 	defer func(file multipart.File) {
 		err := file.Close()
 		if err != nil {
@@ -144,5 +83,11 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		}
 	}(file)
 
-	respondWithJSON(w, http.StatusOK, videoInfo)
+	signedVideo, err := cfg.videoToResponse(videoInfo, audienceOwner)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to sign video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, signedVideo)
 }
@@ -4,27 +4,45 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mediaprobe"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/validation"
 	"github.com/google/uuid"
 	"io"
-	"mime"
+	"log"
+	"math"
 	"net/http"
 	"os"
 	"os/exec"
 	"strings"
-	"time"
 )
 
 type AspectRatio string
 
+// Videos are stored under a key prefix named after their bucket. Keys from
+// before the finer buckets existed may still start with "other/".
 const (
 	AspectRatioLandscape AspectRatio = "landscape"
 	AspectRatioPortrait  AspectRatio = "portrait"
-	AspectRatioOther     AspectRatio = "other"
+	AspectRatioSquare    AspectRatio = "square"
+	AspectRatioUltrawide AspectRatio = "ultrawide"
+	// AspectRatioVertical is exactly 9:16, the shape of phone-first feeds.
+	AspectRatioVertical AspectRatio = "vertical"
+)
+
+const (
+	// squareTolerance lets 1080x1078 style near-squares count as square.
+	squareTolerance = 0.01
+	// ultrawideRatio is the widest ratio that still counts as landscape,
+	// a little below 21:9 cinema formats.
+	ultrawideRatio = 2.0
+	// multipartOverhead is allowed on top of the file size limit for the
+	// form's boundaries and headers.
+	multipartOverhead = 1 << 20
 )
 
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
@@ -68,9 +86,20 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 
 	//parse video from request
 
+	// Stop reading at the size limit instead of spooling an oversized body
+	// to disk first. The slack covers the multipart framing.
+	if cfg.uploadLimits.MaxFileSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, cfg.uploadLimits.MaxFileSize+multipartOverhead)
+	}
+
 	const maxMemory = 10 << 20
 	err = r.ParseMultipartForm(maxMemory)
 	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondWithValidationError(w, validation.SizeExceeded(cfg.uploadLimits))
+			return
+		}
 
 		respondWithError(w, http.StatusBadRequest, "Unable to parse form", err)
 		return
@@ -89,128 +118,251 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	//check header content type
 	headerContentType := header.Header.Get("Content-Type")
 
-	mediaType, _, err1 := mime.ParseMediaType(headerContentType)
-	if err1 != nil {
+	mediaType, err := cfg.checkDeclaredType(headerContentType)
+	if err != nil {
+		respondWithValidationError(w, err)
 		return
 	}
 	fmt.Println("Content Type: ", headerContentType)
 
-	if mediaType != "video/mp4" {
-		respondWithError(w, http.StatusBadRequest, "Invalid content type", nil)
+	if !cfg.setVideoStatus(w, videodb.ID, database.VideoStatusUploading) {
 		return
 	}
 
-	//generate 32bit using random
+	if err := validation.CheckSize(header.Size, cfg.uploadLimits); err != nil {
+		cfg.markVideoFailed(videodb.ID, "upload rejected: "+err.Error())
+		respondWithValidationError(w, err)
+		return
+	}
 
-	var randomId []byte = make([]byte, 32)
-	_, err = rand.Read(randomId)
+	// Validation needs the upload on disk to probe it.
+	tempFile, err := os.CreateTemp(cfg.uploadsRoot, "multipart-*.part")
 	if err != nil {
+		cfg.markVideoFailed(videodb.ID, "upload could not be stored")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store upload", err)
+		return
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+	_, err = io.Copy(tempFile, file)
+	if err == nil {
+		_, err = tempFile.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cfg.markVideoFailed(videodb.ID, "upload could not be stored")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store upload", err)
 		return
 	}
 
-	var stringBase64 = base64.URLEncoding.EncodeToString(randomId)
+	if !cfg.validateVideoUpload(w, r, videodb, tempFile.Name(), mediaType) {
+		return
+	}
 
-	fmt.Printf("Url encoding is %v\n", stringBase64)
+	rawKey, err := cfg.storeRawUpload(r.Context(), videodb, tempFile, mediaType)
+	if err != nil {
+		cfg.markVideoFailed(videodb.ID, "upload could not be stored")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store upload", err)
+		return
+	}
 
-	var tempFile, _ = os.CreateTemp("", "tubely-upload.mp4")
+	cfg.enqueueVideoProcessing(w, videodb, rawKey, mediaType)
+}
 
-	defer os.Remove(tempFile.Name())
+// processAndStoreVideo verifies a fully received upload at path, converts it
+// to MP4 if needed, runs the faststart and aspect ratio steps, stores the
+// result and points the video row at it.
+func (cfg *apiConfig) processAndStoreVideo(ctx context.Context, videodb database.Video, path string, mediaType string) (database.Video, error) {
 
-	defer tempFile.Close()
+	//generate 32bit using random
 
-	written, errFile := io.Copy(tempFile, file)
-	if errFile != nil {
-		return
+	var randomId []byte = make([]byte, 32)
+	_, err := rand.Read(randomId)
+	if err != nil {
+		return videodb, err
 	}
 
-	tempFile.Seek(0, io.SeekStart)
+	var stringBase64 = base64.URLEncoding.EncodeToString(randomId)
+
+	fmt.Printf("Url encoding is %v\n", stringBase64)
+
+	rawProbe, err := mediaprobe.Probe(ctx, path)
+	if err != nil {
+		return videodb, fmt.Errorf("couldn't probe upload: %w", err)
+	}
+	err = cfg.checkProbedType(rawProbe, mediaType)
+	if err != nil {
+		return videodb, err
+	}
 
-	fmt.Printf("The path of the temp file is %v\n", tempFile.Name())
-	fmt.Printf("The written file is %v\n", written)
+	// Everything downstream, players included, expects H.264/AAC in MP4.
+	// Streams that already fit are only remuxed by the faststart step.
+	source := path
+	if needsNormalization(rawProbe) {
+		source, err = normalizeToMP4(ctx, path, rawProbe)
+		if err != nil {
+			return videodb, err
+		}
+		defer os.Remove(source)
+	}
+	originalFormat := probedContentType(rawProbe)
+	videodb.OriginalFormat = &originalFormat
 
-	startFastFile, err := processVideoForFastStart(tempFile.Name())
+	startFastFile, err := processVideoForFastStart(source)
 	if err != nil {
 		fmt.Printf("Error processing video for fast start %v\n", err)
-		return
+		return videodb, err
 	}
 
 	defer os.Remove(startFastFile)
 
-	fileToUpload, _ := os.Open(startFastFile)
+	fileToUpload, err := os.Open(startFastFile)
+	if err != nil {
+		return videodb, err
+	}
+	defer fileToUpload.Close()
+
+	probe, err := mediaprobe.Probe(ctx, startFastFile)
+	if err != nil {
+		return videodb, fmt.Errorf("couldn't probe video: %w", err)
+	}
+
+	err = cfg.db.SaveMediaInfo(mediaInfoFromProbe(videodb.ID, probe))
+	if err != nil {
+		return videodb, fmt.Errorf("couldn't save media info: %w", err)
+	}
+
 	//get aspect ratio
-	fmt.Printf("Line 116\n")
-	aspectRatio, err := getVideoAspectRatio(tempFile.Name())
+	aspectRatio, ratio, err := getVideoAspectRatio(probe)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't get aspect ratio", err)
-		return
+		return videodb, fmt.Errorf("couldn't get aspect ratio: %w", err)
 	}
+	bucket := string(aspectRatio)
+	videodb.AspectBucket = &bucket
+	videodb.AspectRatio = &ratio
 
 	var prefix string = fmt.Sprintf("%s/%s", aspectRatio, stringBase64)
 
 	fmt.Printf("The prefix is %v\n", prefix)
-	var keyFile = fmt.Sprintf("%s.%s", prefix, strings.Split(mediaType, "/")[1])
+	var keyFile = fmt.Sprintf("%s.mp4", prefix)
 
 	fmt.Printf("The key file is %v\n", keyFile)
-	var putObjectInput = s3.PutObjectInput{
-		Bucket:      &cfg.s3Bucket,
-		Key:         &keyFile,
-		Body:        fileToUpload,
-		ContentType: &headerContentType,
-	}
-	_, err = cfg.s3Client.PutObject(context.Background(), &putObjectInput)
+	err = cfg.videoStore.Put(ctx, keyFile, fileToUpload, storage.PutOptions{
+		ContentType: "video/mp4",
+	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't upload video", err)
-		return
+		return videodb, fmt.Errorf("couldn't upload video: %w", err)
 	}
 
 	// update db
-	//	var s3URL = fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", cfg.s3Bucket, cfg.s3Region, keyFile)
-	var s3Tuple = fmt.Sprintf("%s,%s", cfg.s3Bucket, keyFile)
-	//	videodb.VideoURL = &s3URL
-	videodb.VideoURL = &s3Tuple
-	fmt.Printf("The s3 tuple is %v before signed\n", s3Tuple)
+	videodb.Storage = &database.StorageRef{
+		Backend:     cfg.storageBackend,
+		Bucket:      cfg.videoStore.Bucket(),
+		Key:         keyFile,
+		ContentType: "video/mp4",
+	}
 
-	err = cfg.db.UpdateVideo(videodb)
+	streamingKeys, err := cfg.generateStreamingPackages(ctx, startFastFile, prefix, probe)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
-		return
+		return videodb, err
 	}
-
-	videoPresignUrl, e := cfg.dbVideoToSignedVideo(videodb)
-	if e != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video", e)
-		return
+	videodb.HLSKey = nil
+	if streamingKeys.HLS != "" {
+		videodb.HLSKey = &streamingKeys.HLS
+	}
+	videodb.DASHKey = nil
+	if streamingKeys.DASH != "" {
+		videodb.DASHKey = &streamingKeys.DASH
 	}
 
-	respondWithJSON(w, http.StatusOK, videoPresignUrl)
+	videodb.PreviewTrackKey = nil
+	if cfg.spriteInterval > 0 {
+		trackKey, err := cfg.generateSeekPreviews(ctx, startFastFile, prefix, probe)
+		if err != nil {
+			// The video plays fine without hover previews.
+			log.Printf("Couldn't generate seek previews: %v", err)
+		} else {
+			videodb.PreviewTrackKey = &trackKey
+		}
+	}
 
-}
+	var newPreview string
+	if cfg.previewFormat != "" {
+		newPreview, err = cfg.generateAnimatedPreview(ctx, videodb.ID, startFastFile, probe)
+		if err != nil {
+			log.Printf("Couldn't generate animated preview: %v", err)
+		} else {
+			videodb.PreviewKey = &newPreview
+		}
+	}
 
-func getVideoAspectRatio(filepath string) (AspectRatio, error) {
+	err = cfg.db.UpdateVideoMedia(videodb)
+	if err != nil {
+		return videodb, fmt.Errorf("couldn't update video: %w", err)
+	}
+	// Only now that nothing points at the old preview is it safe to remove.
+	if newPreview != "" {
+		cfg.deleteStoredPrefixExcept(ctx, animatedPreviewPrefix(videodb.ID), newPreview)
+	}
 
-	fmt.Printf("The path of the file is %v\n", filepath)
-	cmd := exec.Command("/usr/bin/ffprobe", "-v", "error", "-print_format", "json", "-show_streams", filepath)
-	output, err := cmd.Output()
+	if cfg.autoThumbnails {
+		err = cfg.setAutoThumbnail(ctx, &videodb, startFastFile, probe)
+		if err != nil {
+			return videodb, err
+		}
+	}
+	return videodb, nil
+}
 
+// setAutoThumbnail picks the best frame of source as the video's thumbnail,
+// unless the owner has set one. They may do so while the video processes, so
+// the row is read again here and the update only applies if none is set.
+func (cfg *apiConfig) setAutoThumbnail(ctx context.Context, video *database.Video, source string, probe mediaprobe.Result) error {
+	best, err := cfg.generateThumbnailCandidates(ctx, video.ID, source, probe)
 	if err != nil {
+		// Not worth failing the upload over, the owner can still upload a
+		// thumbnail by hand.
+		log.Printf("Couldn't generate thumbnail candidates: %v", err)
+		return nil
+	}
 
-		fmt.Println("Error running ffprobe", err)
-		return "", err
+	current, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		return fmt.Errorf("couldn't get video: %w", err)
+	}
+	if current.Thumbnail != nil || current.LegacyThumbnailURL != nil {
+		return nil
 	}
-	var result map[string]interface{}
 
-	err = json.Unmarshal(output, &result)
+	thumbnail, err := cfg.storeThumbnail(ctx, video.ID, best)
 	if err != nil {
-		fmt.Println("Error unmarshalling json", err)
-		return "", err
+		return fmt.Errorf("couldn't store thumbnail: %w", err)
+	}
+	set, err := cfg.db.SetDefaultVideoThumbnail(video.ID, thumbnail)
+	if err != nil {
+		return fmt.Errorf("couldn't update video: %w", err)
+	}
+	if !set {
+		// The owner uploaded one in the meantime, so ours is the one to go.
+		cfg.deleteReplacedThumbnail(ctx, &thumbnail)
+		return nil
 	}
+	video.Thumbnail = &thumbnail
+	return nil
+}
 
-	streams := result["streams"].([]interface{})
-	fmt.Printf("The streams are %v\n", streams)
-	firstStream := streams[0].(map[string]interface{})
+// getVideoAspectRatio classifies the first real video stream by the shape it
+// is displayed at, so rotated phone footage and anamorphic video land in the
+// right bucket. It also returns the display width divided by the height.
+func getVideoAspectRatio(probe mediaprobe.Result) (AspectRatio, float64, error) {
 
-	width := firstStream["width"].(float64)
-	height := firstStream["height"].(float64)
+	videoStream, ok := probe.FirstVideo()
+	if !ok {
+		return "", 0, fmt.Errorf("no video stream found")
+	}
+	width, height := videoStream.DisplaySize()
+	if width <= 0 || height <= 0 {
+		return "", 0, fmt.Errorf("video stream has no dimensions")
+	}
 
 	gcd := func(a, b int) int {
 		for b != 0 {
@@ -219,23 +371,26 @@ func getVideoAspectRatio(filepath string) (AspectRatio, error) {
 		return a
 	}
 
-	gcdValue := gcd(int(width), int(height))
-
-	widthS := int(width) / int(gcdValue)
-	heightS := int(height) / int(gcdValue)
-
-	if widthS > heightS {
-		return AspectRatioLandscape, nil
-	} else {
-		if widthS < heightS {
-			return AspectRatioPortrait, nil
-		} else {
-
-			return AspectRatioOther, nil
-		}
+	gcdValue := gcd(width, height)
+
+	widthS := width / gcdValue
+	heightS := height / gcdValue
+	ratio := float64(width) / float64(height)
+
+	switch {
+	case widthS == 9 && heightS == 16:
+		return AspectRatioVertical, ratio, nil
+	case math.Abs(ratio-1) <= squareTolerance:
+		return AspectRatioSquare, ratio, nil
+	case ratio >= ultrawideRatio:
+		return AspectRatioUltrawide, ratio, nil
+	case ratio > 1:
+		return AspectRatioLandscape, ratio, nil
+	default:
+		return AspectRatioPortrait, ratio, nil
 	}
-
 }
+
 func processVideoForFastStart(filepath string) (string, error) {
 	outputFile := filepath + ".processing"
 	fmt.Printf("The original file is %v\n", filepath)
@@ -249,45 +404,52 @@ func processVideoForFastStart(filepath string) (string, error) {
 	return outputFile, nil
 }
 
-func generatePResignedURL(s3client *s3.Client, bucket string, key string, expireTime time.Duration) (string, error) {
-
-	s3PresingC := s3.NewPresignClient(s3client, func(p *s3.PresignOptions) {
-		p.Expires = expireTime
-	})
-
-	req, e := s3PresingC.PresignGetObject(context.Background(), &s3.GetObjectInput{
-		Bucket: &bucket,
-		Key:    &key,
-	})
-
-	if e != nil {
-		return "", e
+// signStorageRef turns a stored object into a URL clients can fetch.
+func (cfg *apiConfig) signStorageRef(ref database.StorageRef, kind mediaKind, audience urlAudience) (signedURL, error) {
+	if ref.Bucket != cfg.videoStore.Bucket() {
+		return signedURL{}, fmt.Errorf("object stored in unknown bucket %q", ref.Bucket)
 	}
-	return req.URL, nil
-
+	return cfg.signKey(context.Background(), ref.Key, cfg.urlLifetimes[audience][kind])
 }
 
-func (cfg *apiConfig) dbVideoToSignedVideo(video database.Video) (database.Video, error) {
-
-	if video.VideoURL == nil {
-		return video, nil
+// resolveListingObject turns a stored thumbnail or preview into a URL. With
+// THUMBNAIL_BASE_URL set these are served unsigned from there.
+func (cfg *apiConfig) resolveListingObject(ref database.StorageRef, audience urlAudience) (string, error) {
+	if cfg.thumbnailBaseURL == "" {
+		signed, err := cfg.signStorageRef(ref, mediaKindImage, audience)
+		return signed.URL, err
 	}
-
-	s3Tuple := strings.Split(*video.VideoURL, ",")
-	fmt.Printf("The s3 tuple is %v after split \n", s3Tuple)
-
-	if len(s3Tuple) != 2 {
-		return video, fmt.Errorf("Invalid S3 Tuple")
+	if ref.Bucket != cfg.videoStore.Bucket() {
+		return "", fmt.Errorf("object stored in unknown bucket %q", ref.Bucket)
 	}
+	return cfg.thumbnailBaseURL + "/" + ref.Key, nil
+}
 
-	s3Bucket := s3Tuple[0]
-	s3Key := s3Tuple[1]
-
-	signedURL, err := generatePResignedURL(cfg.s3Client, s3Bucket, s3Key, 5*time.Minute)
-	if err != nil {
-		return video, err
+// resolveThumbnail resolves a thumbnail into the URL of its largest JPEG,
+// for clients that don't use a srcset, and a srcset per image type.
+func (cfg *apiConfig) resolveThumbnail(thumbnail database.Thumbnail, audience urlAudience) (string, map[string]string, error) {
+	var largest string
+	largestWidth := -1
+	entries := map[string][]string{}
+	for _, rendition := range thumbnail.Renditions {
+		url, err := cfg.resolveListingObject(database.StorageRef{
+			Bucket: thumbnail.Bucket,
+			Key:    thumbnail.Key(rendition),
+		}, audience)
+		if err != nil {
+			return "", nil, err
+		}
+		if rendition.ContentType == "image/jpeg" && rendition.Width > largestWidth {
+			largest, largestWidth = url, rendition.Width
+		}
+		if rendition.Width > 0 {
+			entries[rendition.ContentType] = append(entries[rendition.ContentType], fmt.Sprintf("%s %dw", url, rendition.Width))
+		}
 	}
 
-	video.VideoURL = &signedURL
-	return video, nil
+	srcset := map[string]string{}
+	for mediaType, e := range entries {
+		srcset[mediaType] = strings.Join(e, ", ")
+	}
+	return largest, srcset, nil
 }
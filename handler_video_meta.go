@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -41,7 +42,13 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, video)
+	response, err := cfg.videoToResponse(video, audienceOwner)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't build video response", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response)
 }
 
 func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
@@ -64,7 +71,7 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 	}
 
 	video, err := cfg.db.GetVideo(videoID)
-	if err != nil {
+	if err != nil || video.ID == uuid.Nil || video.Status == database.VideoStatusDeleted {
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
@@ -73,8 +80,14 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	// The row stays so in-flight jobs can still see the video is gone. Its
+	// stored objects are removed in the background, retried until they are.
+	err = cfg.deleteVideoObjects(video)
 	if err != nil {
+		if errors.Is(err, database.ErrInvalidTransition) {
+			respondWithError(w, http.StatusConflict, "Video can't be deleted right now", err)
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
 	}
@@ -91,12 +104,12 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	video, err := cfg.db.GetVideo(videoID)
-	if err != nil {
+	if err != nil || video.ID == uuid.Nil || video.Status == database.VideoStatusDeleted {
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
 
-	videSigned, err := cfg.dbVideoToSignedVideo(video)
+	videSigned, err := cfg.videoToResponse(video, cfg.videoAudience(r, video))
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video", err)
 		return
@@ -124,12 +137,12 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	var videosUrls []database.Video
+	var videosUrls []videoResponse
 	if len(videos) > 0 {
 
 		for i := range videos {
 
-			videoSigned, err := cfg.dbVideoToSignedVideo(videos[i])
+			videoSigned, err := cfg.videoToResponse(videos[i], audienceOwner)
 			if err != nil {
 				respondWithError(w, http.StatusInternalServerError, "Couldn't sign videos", err)
 				return
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// packageManifests are the files served through the API rather than
+// redirected to the store. Players resolve the URIs inside them relative to
+// where they were fetched from, so every variant playlist, segment and sprite
+// sheet they name comes back here too.
+var packageManifests = map[string]bool{
+	".m3u8": true,
+	".mpd":  true,
+	".vtt":  true,
+}
+
+// packagePrefix is where a video's streaming packages and seek previews are
+// stored: the MP4's key without the extension, so landscape/abc.mp4 has its
+// HLS package under landscape/abc/hls/.
+func packagePrefix(video database.Video) string {
+	key := video.Storage.Key
+	return strings.TrimSuffix(key, path.Ext(key)) + "/"
+}
+
+// packageURL is the API URL of a stored package file.
+func (cfg *apiConfig) packageURL(video database.Video, key string) string {
+	return cfg.publicBaseURL + "/api/videos/" + video.ID.String() + "/packages/" + strings.TrimPrefix(key, packagePrefix(video))
+}
+
+// handlerVideoPackageGet serves a file of a video's HLS or DASH package or
+// of its seek preview track. A private bucket can't be reached by the
+// relative fetches players make, so manifests come from here and everything
+// else is redirected to a freshly signed URL.
+func (cfg *apiConfig) handlerVideoPackageGet(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil || video.Status == database.VideoStatusDeleted {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.Storage == nil || video.Storage.Bucket != cfg.videoStore.Bucket() {
+		respondWithError(w, http.StatusNotFound, "Video has no media yet", nil)
+		return
+	}
+
+	name := r.PathValue("path")
+	if name == "" || path.Clean("/"+name) != "/"+name {
+		respondWithError(w, http.StatusBadRequest, "Invalid package path", nil)
+		return
+	}
+	key := packagePrefix(video) + name
+
+	if !packageManifests[path.Ext(name)] {
+		signed, err := cfg.signKey(r.Context(), key, cfg.urlLifetimes[cfg.videoAudience(r, video)][mediaKindStream])
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				respondWithError(w, http.StatusNotFound, "Media not found", err)
+				return
+			}
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign media", err)
+			return
+		}
+		w.Header().Set("Cache-Control", "private, no-store")
+		http.Redirect(w, r, signed.URL, http.StatusFound)
+		return
+	}
+
+	body, info, err := cfg.videoStore.Get(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			respondWithError(w, http.StatusNotFound, "Media not found", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read media", err)
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", contentTypeForKey(key))
+	if info.ETag != "" {
+		w.Header().Set("ETag", info.ETag)
+	}
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	io.Copy(w, body)
+}
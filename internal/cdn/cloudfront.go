@@ -0,0 +1,204 @@
+// Package cdn signs CloudFront URLs and cookies for private distributions.
+package cdn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Signer signs with one of the distribution's trusted key pairs.
+type Signer struct {
+	keyPairID string
+	key       *rsa.PrivateKey
+}
+
+// NewSigner parses an RSA private key in PEM form, either PKCS#1 as
+// CloudFront hands it out or PKCS#8.
+func NewSigner(keyPairID string, privateKeyPEM []byte) (*Signer, error) {
+	if keyPairID == "" {
+		return nil, fmt.Errorf("key pair ID is required")
+	}
+	block, _ := pem.Decode(privateKeyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in private key")
+	}
+
+	var key *rsa.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		k, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = k
+	case "PRIVATE KEY":
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		rsaKey, ok := k.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("CloudFront keys must be RSA")
+		}
+		key = rsaKey
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %q", block.Type)
+	}
+	return &Signer{keyPairID: keyPairID, key: key}, nil
+}
+
+// Policy is a custom policy. Resource may end in * to cover every object
+// under a path. NotBefore and IPAddress (a CIDR) are optional.
+type Policy struct {
+	Resource  string
+	Expires   time.Time
+	NotBefore time.Time
+	IPAddress string
+}
+
+type epochTime struct {
+	EpochTime int64 `json:"AWS:EpochTime"`
+}
+
+type sourceIP struct {
+	SourceIP string `json:"AWS:SourceIp"`
+}
+
+type policyCondition struct {
+	DateLessThan    epochTime  `json:"DateLessThan"`
+	DateGreaterThan *epochTime `json:"DateGreaterThan,omitempty"`
+	IPAddress       *sourceIP  `json:"IpAddress,omitempty"`
+}
+
+type policyStatement struct {
+	Resource  string          `json:"Resource"`
+	Condition policyCondition `json:"Condition"`
+}
+
+type policyDocument struct {
+	Statement []policyStatement `json:"Statement"`
+}
+
+// document renders the policy as JSON. CloudFront rebuilds canned policies
+// byte for byte from the URL, so HTML escaping of & and friends has to stay
+// off and there must be no trailing newline.
+func (p Policy) document() ([]byte, error) {
+	statement := policyStatement{Resource: p.Resource}
+	statement.Condition.DateLessThan.EpochTime = p.Expires.Unix()
+	if !p.NotBefore.IsZero() {
+		statement.Condition.DateGreaterThan = &epochTime{EpochTime: p.NotBefore.Unix()}
+	}
+	if p.IPAddress != "" {
+		statement.Condition.IPAddress = &sourceIP{SourceIP: p.IPAddress}
+	}
+	doc := policyDocument{Statement: []policyStatement{statement}}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// canned reports whether the policy fits CloudFront's canned form, which
+// keeps signed URLs shorter.
+func (p Policy) canned() bool {
+	return p.NotBefore.IsZero() && p.IPAddress == "" && !strings.Contains(p.Resource, "*")
+}
+
+// SignURL signs rawURL with a canned policy valid until expires.
+func (s *Signer) SignURL(rawURL string, expires time.Time) (string, error) {
+	return s.SignURLWithPolicy(rawURL, Policy{Resource: rawURL, Expires: expires})
+}
+
+// SignURLWithPolicy signs rawURL under p. Policies that only set Resource to
+// rawURL and Expires are sent canned, anything else as a custom policy.
+func (s *Signer) SignURLWithPolicy(rawURL string, p Policy) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	doc, err := p.document()
+	if err != nil {
+		return "", err
+	}
+	signature, err := s.sign(doc)
+	if err != nil {
+		return "", err
+	}
+
+	// Appended by hand rather than through url.Values, which would sort the
+	// parameters into the existing query.
+	params := []string{}
+	if p.canned() && p.Resource == rawURL {
+		params = append(params, fmt.Sprintf("Expires=%d", p.Expires.Unix()))
+	} else {
+		params = append(params, "Policy="+encode(doc))
+	}
+	params = append(params, "Signature="+signature, "Key-Pair-Id="+s.keyPairID)
+
+	separator := "?"
+	if u.RawQuery != "" {
+		separator = "&"
+	}
+	return rawURL + separator + strings.Join(params, "&"), nil
+}
+
+// SignedCookies returns the CloudFront-* cookies granting access under p.
+// The caller sets Domain, Path and Secure to match the distribution.
+func (s *Signer) SignedCookies(p Policy) ([]*http.Cookie, error) {
+	doc, err := p.document()
+	if err != nil {
+		return nil, err
+	}
+	signature, err := s.sign(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	cookies := []*http.Cookie{}
+	if p.canned() {
+		cookies = append(cookies, &http.Cookie{Name: "CloudFront-Expires", Value: fmt.Sprint(p.Expires.Unix())})
+	} else {
+		cookies = append(cookies, &http.Cookie{Name: "CloudFront-Policy", Value: encode(doc)})
+	}
+	cookies = append(cookies,
+		&http.Cookie{Name: "CloudFront-Signature", Value: signature},
+		&http.Cookie{Name: "CloudFront-Key-Pair-Id", Value: s.keyPairID},
+	)
+	for _, c := range cookies {
+		c.Expires = p.Expires
+		c.HttpOnly = true
+	}
+	return cookies, nil
+}
+
+// sign is RSA-SHA1, the only algorithm CloudFront accepts for key pairs.
+func (s *Signer) sign(doc []byte) (string, error) {
+	digest := sha1.Sum(doc)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA1, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return encode(signature), nil
+}
+
+// encode is CloudFront's URL safe base64: + becomes -, = becomes _ and /
+// becomes ~.
+func encode(b []byte) string {
+	return strings.NewReplacer("+", "-", "=", "_", "/", "~").Replace(base64.StdEncoding.EncodeToString(b))
+}
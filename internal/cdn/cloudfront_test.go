@@ -0,0 +1,230 @@
+package cdn
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testKeyPairID = "K2JCJMDEHXQW5F"
+
+var testExpires = time.Unix(1767225600, 0)
+
+func newTestSigner(t *testing.T) (*Signer, *rsa.PublicKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+	signer, err := NewSigner(testKeyPairID, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, &key.PublicKey
+}
+
+// decode undoes CloudFront's URL safe base64.
+func decode(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := base64.StdEncoding.DecodeString(strings.NewReplacer("-", "+", "_", "=", "~", "/").Replace(s))
+	if err != nil {
+		t.Fatalf("couldn't decode %q: %v", s, err)
+	}
+	return data
+}
+
+// verify checks signature against the exact policy bytes CloudFront will
+// hash.
+func verify(t *testing.T, pub *rsa.PublicKey, policy []byte, signature string) {
+	t.Helper()
+	digest := sha1.Sum(policy)
+	err := rsa.VerifyPKCS1v15(pub, crypto.SHA1, digest[:], decode(t, signature))
+	if err != nil {
+		t.Errorf("signature doesn't verify over %s: %v", policy, err)
+	}
+}
+
+func TestSignURLCanned(t *testing.T) {
+	signer, pub := newTestSigner(t)
+	// The & must stay as is in the policy: CloudFront rebuilds a canned
+	// policy from the URL and compares byte for byte.
+	rawURL := "https://d111111abcdef8.cloudfront.net/landscape/abc.mp4?a=1&b=2"
+
+	signed, err := signer.SignURL(rawURL, testExpires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, query, ok := strings.Cut(signed, "?a=1&b=2&")
+	if !ok || base != "https://d111111abcdef8.cloudfront.net/landscape/abc.mp4" {
+		t.Fatalf("signed URL %q doesn't keep the original query first", signed)
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := params.Get("Expires"); got != "1767225600" {
+		t.Errorf("Expires = %q, want 1767225600", got)
+	}
+	if params.Has("Policy") {
+		t.Errorf("canned URL carries a Policy")
+	}
+	if got := params.Get("Key-Pair-Id"); got != testKeyPairID {
+		t.Errorf("Key-Pair-Id = %q, want %q", got, testKeyPairID)
+	}
+
+	// The canned policy format from the CloudFront developer guide.
+	want := `{"Statement":[{"Resource":"https://d111111abcdef8.cloudfront.net/landscape/abc.mp4?a=1&b=2","Condition":{"DateLessThan":{"AWS:EpochTime":1767225600}}}]}`
+	doc, err := Policy{Resource: rawURL, Expires: testExpires}.document()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(doc) != want {
+		t.Errorf("canned policy\n got %s\nwant %s", doc, want)
+	}
+	verify(t, pub, []byte(want), params.Get("Signature"))
+}
+
+func TestSignURLCustomPolicy(t *testing.T) {
+	signer, pub := newTestSigner(t)
+	rawURL := "https://d111111abcdef8.cloudfront.net/landscape/abc/hls/master.m3u8"
+
+	signed, err := signer.SignURLWithPolicy(rawURL, Policy{
+		Resource:  "https://d111111abcdef8.cloudfront.net/landscape/abc/*",
+		Expires:   testExpires,
+		NotBefore: time.Unix(1767222000, 0),
+		IPAddress: "192.0.2.0/24",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, query, ok := strings.Cut(signed, "?")
+	if !ok || base != rawURL {
+		t.Fatalf("signed URL %q doesn't start with %q", signed, rawURL)
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Has("Expires") {
+		t.Errorf("custom policy URL carries Expires")
+	}
+
+	policy := decode(t, params.Get("Policy"))
+	want := `{"Statement":[{"Resource":"https://d111111abcdef8.cloudfront.net/landscape/abc/*","Condition":{"DateLessThan":{"AWS:EpochTime":1767225600},"DateGreaterThan":{"AWS:EpochTime":1767222000},"IpAddress":{"AWS:SourceIp":"192.0.2.0/24"}}}]}`
+	if string(policy) != want {
+		t.Errorf("custom policy\n got %s\nwant %s", policy, want)
+	}
+	verify(t, pub, policy, params.Get("Signature"))
+}
+
+func TestSignedCookies(t *testing.T) {
+	signer, pub := newTestSigner(t)
+
+	tests := []struct {
+		name   string
+		policy Policy
+		want   string
+	}{
+		{
+			name:   "canned",
+			policy: Policy{Resource: "https://d111111abcdef8.cloudfront.net/landscape/abc.mp4", Expires: testExpires},
+			want:   `{"Statement":[{"Resource":"https://d111111abcdef8.cloudfront.net/landscape/abc.mp4","Condition":{"DateLessThan":{"AWS:EpochTime":1767225600}}}]}`,
+		},
+		{
+			name:   "custom",
+			policy: Policy{Resource: "https://d111111abcdef8.cloudfront.net/landscape/abc/*", Expires: testExpires},
+			want:   `{"Statement":[{"Resource":"https://d111111abcdef8.cloudfront.net/landscape/abc/*","Condition":{"DateLessThan":{"AWS:EpochTime":1767225600}}}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cookies, err := signer.SignedCookies(tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values := map[string]string{}
+			for _, c := range cookies {
+				values[c.Name] = c.Value
+				if !c.HttpOnly || !c.Expires.Equal(testExpires) {
+					t.Errorf("cookie %s: HttpOnly %v, Expires %v", c.Name, c.HttpOnly, c.Expires)
+				}
+			}
+			if values["CloudFront-Key-Pair-Id"] != testKeyPairID {
+				t.Errorf("CloudFront-Key-Pair-Id = %q", values["CloudFront-Key-Pair-Id"])
+			}
+
+			if tt.name == "canned" {
+				if values["CloudFront-Expires"] != "1767225600" {
+					t.Errorf("CloudFront-Expires = %q", values["CloudFront-Expires"])
+				}
+				if _, ok := values["CloudFront-Policy"]; ok {
+					t.Errorf("canned cookies carry a policy")
+				}
+			} else {
+				if got := decode(t, values["CloudFront-Policy"]); string(got) != tt.want {
+					t.Errorf("policy\n got %s\nwant %s", got, tt.want)
+				}
+			}
+			verify(t, pub, []byte(tt.want), values["CloudFront-Signature"])
+
+			// CloudFront reads the values as sent, so net/http must not have
+			// to quote them.
+			for _, c := range cookies {
+				if strings.Contains(c.String(), `"`) {
+					t.Errorf("cookie %s is quoted: %s", c.Name, c)
+				}
+			}
+		})
+	}
+}
+
+func TestNewSignerPKCS8(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewSigner(testKeyPairID, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := signer.SignURL("https://d111111abcdef8.cloudfront.net/a.jpg", testExpires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"Statement":[{"Resource":"https://d111111abcdef8.cloudfront.net/a.jpg","Condition":{"DateLessThan":{"AWS:EpochTime":1767225600}}}]}`
+	verify(t, &key.PublicKey, []byte(want), u.Query().Get("Signature"))
+}
+
+func TestNewSignerRejectsBadKeys(t *testing.T) {
+	if _, err := NewSigner("", nil); err == nil {
+		t.Error("missing key pair ID accepted")
+	}
+	if _, err := NewSigner(testKeyPairID, []byte("not a key")); err == nil {
+		t.Error("non-PEM key accepted")
+	}
+	block := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{1}})
+	if _, err := NewSigner(testKeyPairID, block); err == nil {
+		t.Error("certificate accepted as a key")
+	}
+}
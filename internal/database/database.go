@@ -72,9 +72,166 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+	err = c.migrateVideoStatus()
+	if err != nil {
+		return err
+	}
+	for _, column := range videoColumnMigrations {
+		_, err = c.addColumnIfMissing("videos", column.name, column.definition)
+		if err != nil {
+			return err
+		}
+	}
+
+	uploadTable := `
+	CREATE TABLE IF NOT EXISTS uploads (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		completed_at TIMESTAMP,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		media_type TEXT NOT NULL,
+		upload_length INTEGER NOT NULL,
+		upload_offset INTEGER NOT NULL DEFAULT 0,
+		path TEXT NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(uploadTable)
+	if err != nil {
+		return err
+	}
+
+	mediaInfoTable := `
+	CREATE TABLE IF NOT EXISTS video_media_info (
+		video_id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		format_name TEXT NOT NULL,
+		duration_seconds REAL NOT NULL,
+		bit_rate INTEGER NOT NULL,
+		size INTEGER NOT NULL,
+		video_codec TEXT,
+		width INTEGER,
+		height INTEGER,
+		frame_rate REAL,
+		rotation INTEGER,
+		audio_codec TEXT,
+		channel_layout TEXT,
+		sample_rate INTEGER,
+		streams TEXT NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(mediaInfoTable)
+	if err != nil {
+		return err
+	}
+
+	thumbnailCandidateTable := `
+	CREATE TABLE IF NOT EXISTS thumbnail_candidates (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		storage_key TEXT NOT NULL,
+		source TEXT NOT NULL,
+		position_seconds REAL,
+		brightness REAL NOT NULL,
+		contrast REAL NOT NULL,
+		sharpness REAL NOT NULL,
+		score REAL NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_thumbnail_candidates_video_id ON thumbnail_candidates(video_id);
+	`
+	_, err = c.db.Exec(thumbnailCandidateTable)
+	if err != nil {
+		return err
+	}
+
+	jobTable := `
+	CREATE TABLE IF NOT EXISTS jobs (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		type TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		state TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		max_attempts INTEGER NOT NULL,
+		last_error TEXT,
+		run_at TIMESTAMP NOT NULL,
+		started_at TIMESTAMP,
+		finished_at TIMESTAMP,
+		video_id TEXT,
+		user_id TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_jobs_state_run_at ON jobs(state, run_at);
+	`
+	_, err = c.db.Exec(jobTable)
+	if err != nil {
+		return err
+	}
+
+	storageDeletionTable := `
+	CREATE TABLE IF NOT EXISTS storage_deletions (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		store TEXT NOT NULL,
+		key TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT,
+		run_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_storage_deletions_run_at ON storage_deletions(run_at);
+	`
+	_, err = c.db.Exec(storageDeletionTable)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
+// addColumnIfMissing adds a column to an existing table, since CREATE TABLE IF
+// NOT EXISTS never changes a table created by an older version. It reports
+// whether the column was added.
+func (c *Client) addColumnIfMissing(table, column, definition string) (bool, error) {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return false, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	rows.Close()
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (c Client) Reset() error {
 	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
@@ -82,6 +239,21 @@ func (c Client) Reset() error {
 	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
 		return fmt.Errorf("failed to reset table users: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM jobs"); err != nil {
+		return fmt.Errorf("failed to reset table jobs: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM storage_deletions"); err != nil {
+		return fmt.Errorf("failed to reset table storage_deletions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM thumbnail_candidates"); err != nil {
+		return fmt.Errorf("failed to reset table thumbnail_candidates: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_media_info"); err != nil {
+		return fmt.Errorf("failed to reset table video_media_info: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM uploads"); err != nil {
+		return fmt.Errorf("failed to reset table uploads: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
 		return fmt.Errorf("failed to reset table videos: %w", err)
 	}
@@ -0,0 +1,229 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type JobState string
+
+const (
+	JobStateQueued    JobState = "queued"
+	JobStateRunning   JobState = "running"
+	JobStateSucceeded JobState = "succeeded"
+	JobStateFailed    JobState = "failed"
+)
+
+type Job struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	State      JobState   `json:"state"`
+	Attempts   int        `json:"attempts"`
+	LastError  *string    `json:"last_error"`
+	RunAt      time.Time  `json:"run_at"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	CreateJobParams
+}
+
+type CreateJobParams struct {
+	Type        string     `json:"type"`
+	Payload     string     `json:"-"`
+	MaxAttempts int        `json:"max_attempts"`
+	VideoID     *uuid.UUID `json:"video_id"`
+	UserID      *uuid.UUID `json:"user_id"`
+}
+
+const jobColumns = `
+		id,
+		created_at,
+		updated_at,
+		type,
+		payload,
+		state,
+		attempts,
+		max_attempts,
+		last_error,
+		run_at,
+		started_at,
+		finished_at,
+		video_id,
+		user_id
+`
+
+func scanJob(row interface{ Scan(...any) error }) (Job, error) {
+	var job Job
+	err := row.Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.Type,
+		&job.Payload,
+		&job.State,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.LastError,
+		&job.RunAt,
+		&job.StartedAt,
+		&job.FinishedAt,
+		&job.VideoID,
+		&job.UserID,
+	)
+	return job, err
+}
+
+func (c Client) CreateJob(params CreateJobParams) (Job, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO jobs (
+		id,
+		created_at,
+		updated_at,
+		type,
+		payload,
+		state,
+		attempts,
+		max_attempts,
+		run_at,
+		video_id,
+		user_id
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, 0, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		id,
+		params.Type,
+		params.Payload,
+		JobStateQueued,
+		params.MaxAttempts,
+		time.Now().UTC(),
+		params.VideoID,
+		params.UserID,
+	)
+	if err != nil {
+		return Job{}, err
+	}
+
+	return c.GetJob(id)
+}
+
+func (c Client) GetJob(id uuid.UUID) (Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE id = ?`
+
+	job, err := scanJob(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, nil
+		}
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// ClaimNextJob marks the oldest runnable queued job as running and returns
+// it. ok is false when there is nothing to do. The conditional update makes
+// claiming safe when several workers poll at the same time.
+func (c Client) ClaimNextJob() (job Job, ok bool, err error) {
+	for {
+		query := `SELECT ` + jobColumns + `
+		FROM jobs
+		WHERE state = ? AND run_at <= ?
+		ORDER BY run_at
+		LIMIT 1
+		`
+		job, err = scanJob(c.db.QueryRow(query, JobStateQueued, time.Now().UTC()))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return Job{}, false, nil
+			}
+			return Job{}, false, err
+		}
+
+		res, err := c.db.Exec(`
+		UPDATE jobs
+		SET
+			state = ?,
+			attempts = attempts + 1,
+			started_at = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND state = ?
+		`, JobStateRunning, time.Now().UTC(), job.ID, JobStateQueued)
+		if err != nil {
+			return Job{}, false, err
+		}
+		claimed, err := res.RowsAffected()
+		if err != nil {
+			return Job{}, false, err
+		}
+		if claimed == 1 {
+			job, err = c.GetJob(job.ID)
+			return job, err == nil, err
+		}
+		// Another worker got there first, look for the next one.
+	}
+}
+
+func (c Client) CompleteJob(id uuid.UUID) error {
+	query := `
+	UPDATE jobs
+	SET
+		state = ?,
+		last_error = NULL,
+		finished_at = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobStateSucceeded, time.Now().UTC(), id)
+	return err
+}
+
+// RetryJob puts a failed attempt back in the queue to run again at runAt.
+func (c Client) RetryJob(id uuid.UUID, jobErr string, runAt time.Time) error {
+	query := `
+	UPDATE jobs
+	SET
+		state = ?,
+		last_error = ?,
+		run_at = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobStateQueued, jobErr, runAt.UTC(), id)
+	return err
+}
+
+func (c Client) FailJob(id uuid.UUID, jobErr string) error {
+	query := `
+	UPDATE jobs
+	SET
+		state = ?,
+		last_error = ?,
+		finished_at = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobStateFailed, jobErr, time.Now().UTC(), id)
+	return err
+}
+
+// RequeueRunningJobs returns jobs that were running when the process stopped
+// to the queue. Only call it before any worker has started.
+func (c Client) RequeueRunningJobs() (int64, error) {
+	query := `
+	UPDATE jobs
+	SET
+		state = ?,
+		run_at = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE state = ?
+	`
+	res, err := c.db.Exec(query, JobStateQueued, time.Now().UTC(), JobStateRunning)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
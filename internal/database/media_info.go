@@ -0,0 +1,196 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MediaInfo is what ffprobe found in a video's upload. The first video and
+// audio streams are flattened into columns so they can be filtered on; every
+// stream is kept in Streams.
+type MediaInfo struct {
+	VideoID         uuid.UUID     `json:"-"`
+	FormatName      string        `json:"format_name"`
+	DurationSeconds float64       `json:"duration_seconds"`
+	BitRate         int64         `json:"bit_rate"`
+	Size            int64         `json:"size"`
+	VideoCodec      *string       `json:"video_codec"`
+	Width           *int          `json:"width"`
+	Height          *int          `json:"height"`
+	FrameRate       *float64      `json:"frame_rate"`
+	Rotation        *int          `json:"rotation"`
+	AudioCodec      *string       `json:"audio_codec"`
+	ChannelLayout   *string       `json:"channel_layout"`
+	SampleRate      *int          `json:"sample_rate"`
+	Streams         []MediaStream `json:"streams"`
+	UpdatedAt       time.Time     `json:"updated_at"`
+}
+
+type MediaStream struct {
+	Index             int     `json:"index"`
+	CodecType         string  `json:"codec_type"`
+	CodecName         string  `json:"codec_name"`
+	Profile           string  `json:"profile,omitempty"`
+	BitRate           int64   `json:"bit_rate,omitempty"`
+	Width             int     `json:"width,omitempty"`
+	Height            int     `json:"height,omitempty"`
+	FrameRate         float64 `json:"frame_rate,omitempty"`
+	Rotation          int     `json:"rotation,omitempty"`
+	SampleAspectRatio string  `json:"sample_aspect_ratio,omitempty"`
+	PixelFormat       string  `json:"pixel_format,omitempty"`
+	ChannelLayout     string  `json:"channel_layout,omitempty"`
+	Channels          int     `json:"channels,omitempty"`
+	SampleRate        int     `json:"sample_rate,omitempty"`
+}
+
+const mediaInfoColumns = `
+		video_id,
+		format_name,
+		duration_seconds,
+		bit_rate,
+		size,
+		video_codec,
+		width,
+		height,
+		frame_rate,
+		rotation,
+		audio_codec,
+		channel_layout,
+		sample_rate,
+		streams,
+		updated_at
+`
+
+func scanMediaInfo(row interface{ Scan(...any) error }) (MediaInfo, error) {
+	var info MediaInfo
+	var streams string
+	err := row.Scan(
+		&info.VideoID,
+		&info.FormatName,
+		&info.DurationSeconds,
+		&info.BitRate,
+		&info.Size,
+		&info.VideoCodec,
+		&info.Width,
+		&info.Height,
+		&info.FrameRate,
+		&info.Rotation,
+		&info.AudioCodec,
+		&info.ChannelLayout,
+		&info.SampleRate,
+		&streams,
+		&info.UpdatedAt,
+	)
+	if err != nil {
+		return MediaInfo{}, err
+	}
+	err = json.Unmarshal([]byte(streams), &info.Streams)
+	if err != nil {
+		return MediaInfo{}, err
+	}
+	return info, nil
+}
+
+// SaveMediaInfo stores the probe results of a video, replacing any earlier
+// ones from a previous upload.
+func (c Client) SaveMediaInfo(info MediaInfo) error {
+	streams, err := json.Marshal(info.Streams)
+	if err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO video_media_info (
+		video_id,
+		created_at,
+		updated_at,
+		format_name,
+		duration_seconds,
+		bit_rate,
+		size,
+		video_codec,
+		width,
+		height,
+		frame_rate,
+		rotation,
+		audio_codec,
+		channel_layout,
+		sample_rate,
+		streams
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(video_id) DO UPDATE SET
+		updated_at = CURRENT_TIMESTAMP,
+		format_name = excluded.format_name,
+		duration_seconds = excluded.duration_seconds,
+		bit_rate = excluded.bit_rate,
+		size = excluded.size,
+		video_codec = excluded.video_codec,
+		width = excluded.width,
+		height = excluded.height,
+		frame_rate = excluded.frame_rate,
+		rotation = excluded.rotation,
+		audio_codec = excluded.audio_codec,
+		channel_layout = excluded.channel_layout,
+		sample_rate = excluded.sample_rate,
+		streams = excluded.streams
+	`
+	_, err = c.db.Exec(
+		query,
+		info.VideoID,
+		info.FormatName,
+		info.DurationSeconds,
+		info.BitRate,
+		info.Size,
+		info.VideoCodec,
+		info.Width,
+		info.Height,
+		info.FrameRate,
+		info.Rotation,
+		info.AudioCodec,
+		info.ChannelLayout,
+		info.SampleRate,
+		string(streams),
+	)
+	return err
+}
+
+func (c Client) GetMediaInfo(videoID uuid.UUID) (*MediaInfo, error) {
+	query := `SELECT ` + mediaInfoColumns + ` FROM video_media_info WHERE video_id = ?`
+
+	info, err := scanMediaInfo(c.db.QueryRow(query, videoID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &info, nil
+}
+
+// getMediaInfoForUser loads the media info of all of a user's videos in one
+// query, keyed by video ID.
+func (c Client) getMediaInfoForUser(userID uuid.UUID) (map[uuid.UUID]*MediaInfo, error) {
+	query := `
+	SELECT ` + mediaInfoColumns + `
+	FROM video_media_info
+	WHERE video_id IN (SELECT id FROM videos WHERE user_id = ?)
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	infos := map[uuid.UUID]*MediaInfo{}
+	for rows.Next() {
+		info, err := scanMediaInfo(rows)
+		if err != nil {
+			return nil, err
+		}
+		infos[info.VideoID] = &info
+	}
+	return infos, rows.Err()
+}
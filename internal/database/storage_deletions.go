@@ -0,0 +1,95 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Stores a storage deletion can target.
+const (
+	// StorageDeletionVideoStore is the configured video store.
+	StorageDeletionVideoStore = "video"
+	// StorageDeletionAssets is the local assets directory, where thumbnails
+	// were written before they moved into the video store.
+	StorageDeletionAssets = "assets"
+)
+
+// StorageDeletion is an outbox entry for objects that must be removed from
+// storage. It is kept until the delete succeeds, however many attempts that
+// takes.
+type StorageDeletion struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	Attempts  int
+	LastError *string
+	RunAt     time.Time
+	CreateStorageDeletionParams
+}
+
+type CreateStorageDeletionParams struct {
+	VideoID uuid.UUID
+	Store   string
+	// Key is a single object, or every object under it when it ends in "/".
+	Key string
+}
+
+// GetDueStorageDeletions returns up to limit deletions whose next attempt is
+// due, oldest first.
+func (c Client) GetDueStorageDeletions(limit int) ([]StorageDeletion, error) {
+	query := `
+	SELECT id, created_at, video_id, store, key, attempts, last_error, run_at
+	FROM storage_deletions
+	WHERE run_at <= ?
+	ORDER BY run_at
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, time.Now().UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deletions := []StorageDeletion{}
+	for rows.Next() {
+		var deletion StorageDeletion
+		err := rows.Scan(
+			&deletion.ID,
+			&deletion.CreatedAt,
+			&deletion.VideoID,
+			&deletion.Store,
+			&deletion.Key,
+			&deletion.Attempts,
+			&deletion.LastError,
+			&deletion.RunAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, deletion)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return deletions, nil
+}
+
+// CompleteStorageDeletion drops a deletion once its objects are gone.
+func (c Client) CompleteStorageDeletion(id uuid.UUID) error {
+	_, err := c.db.Exec(`DELETE FROM storage_deletions WHERE id = ?`, id)
+	return err
+}
+
+// RetryStorageDeletion records a failed attempt and when to try again.
+func (c Client) RetryStorageDeletion(id uuid.UUID, deleteErr string, runAt time.Time) error {
+	query := `
+	UPDATE storage_deletions
+	SET
+		attempts = attempts + 1,
+		last_error = ?,
+		run_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, deleteErr, runAt.UTC(), id)
+	return err
+}
@@ -0,0 +1,55 @@
+package database
+
+// StorageRef locates a stored object. Keys may contain any character, so the
+// parts are kept in their own columns rather than joined into one string.
+type StorageRef struct {
+	// Backend is the kind of store holding the object: s3, local or memory.
+	Backend     string
+	Bucket      string
+	Key         string
+	ContentType string
+}
+
+// MigrateStorageRefs splits the "bucket,key" tuples rows used to keep in
+// video_url into the storage columns. Bucket names can't contain a comma, so
+// everything after the first one is the key. Every stored video was an MP4,
+// kept in the store the server is configured with, so backend is taken from
+// the configuration rather than the bucket name. video_url is cleared so the
+// tuple is never read as a URL again.
+func (c Client) MigrateStorageRefs(backend string) error {
+	_, err := c.db.Exec(`
+	UPDATE videos
+	SET
+		storage_bucket = substr(video_url, 1, instr(video_url, ',') - 1),
+		storage_key = substr(video_url, instr(video_url, ',') + 1),
+		storage_backend = ?,
+		content_type = 'video/mp4',
+		video_url = NULL
+	WHERE storage_key IS NULL AND instr(video_url, ',') > 0
+	`, backend)
+	return err
+}
+
+// Thumbnail is a thumbnail's renditions, all stored under Prefix in Bucket.
+type Thumbnail struct {
+	Bucket     string
+	Prefix     string
+	Renditions []ThumbnailRendition
+	// BlurHash and LQIP stand in for the thumbnail while it loads: a
+	// BlurHash string and a tiny inline data URL.
+	BlurHash string
+	LQIP     string
+}
+
+// ThumbnailRendition is one encoding of a thumbnail at one width.
+type ThumbnailRendition struct {
+	// Name is the object's key relative to the thumbnail's Prefix.
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+}
+
+// Key is where the rendition is stored.
+func (t Thumbnail) Key(rendition ThumbnailRendition) string {
+	return t.Prefix + rendition.Name
+}
@@ -0,0 +1,139 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ThumbnailCandidate struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateThumbnailCandidateParams
+}
+
+type CreateThumbnailCandidateParams struct {
+	VideoID    uuid.UUID `json:"video_id"`
+	StorageKey string    `json:"-"`
+	// Source is "position" for frames taken at a fixed share of the duration
+	// and "scene" for frames taken at scene changes.
+	Source          string   `json:"source"`
+	PositionSeconds *float64 `json:"position_seconds"`
+	Brightness      float64  `json:"brightness"`
+	Contrast        float64  `json:"contrast"`
+	Sharpness       float64  `json:"sharpness"`
+	Score           float64  `json:"score"`
+}
+
+func (c Client) CreateThumbnailCandidate(params CreateThumbnailCandidateParams) (ThumbnailCandidate, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO thumbnail_candidates (
+		id,
+		created_at,
+		video_id,
+		storage_key,
+		source,
+		position_seconds,
+		brightness,
+		contrast,
+		sharpness,
+		score
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		id,
+		params.VideoID,
+		params.StorageKey,
+		params.Source,
+		params.PositionSeconds,
+		params.Brightness,
+		params.Contrast,
+		params.Sharpness,
+		params.Score,
+	)
+	if err != nil {
+		return ThumbnailCandidate{}, err
+	}
+	return c.GetThumbnailCandidate(id)
+}
+
+const thumbnailCandidateColumns = `
+		id,
+		created_at,
+		video_id,
+		storage_key,
+		source,
+		position_seconds,
+		brightness,
+		contrast,
+		sharpness,
+		score
+`
+
+func scanThumbnailCandidate(row interface{ Scan(...any) error }) (ThumbnailCandidate, error) {
+	var candidate ThumbnailCandidate
+	err := row.Scan(
+		&candidate.ID,
+		&candidate.CreatedAt,
+		&candidate.VideoID,
+		&candidate.StorageKey,
+		&candidate.Source,
+		&candidate.PositionSeconds,
+		&candidate.Brightness,
+		&candidate.Contrast,
+		&candidate.Sharpness,
+		&candidate.Score,
+	)
+	return candidate, err
+}
+
+func (c Client) GetThumbnailCandidate(id uuid.UUID) (ThumbnailCandidate, error) {
+	query := `SELECT ` + thumbnailCandidateColumns + ` FROM thumbnail_candidates WHERE id = ?`
+
+	candidate, err := scanThumbnailCandidate(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ThumbnailCandidate{}, nil
+		}
+		return ThumbnailCandidate{}, err
+	}
+	return candidate, nil
+}
+
+// GetThumbnailCandidates lists a video's candidates, best score first.
+func (c Client) GetThumbnailCandidates(videoID uuid.UUID) ([]ThumbnailCandidate, error) {
+	query := `
+	SELECT ` + thumbnailCandidateColumns + `
+	FROM thumbnail_candidates
+	WHERE video_id = ?
+	ORDER BY score DESC
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []ThumbnailCandidate{}
+	for rows.Next() {
+		candidate, err := scanThumbnailCandidate(rows)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, rows.Err()
+}
+
+func (c Client) DeleteThumbnailCandidates(videoID uuid.UUID) error {
+	query := `
+	DELETE FROM thumbnail_candidates
+	WHERE video_id = ?
+	`
+	_, err := c.db.Exec(query, videoID)
+	return err
+}
@@ -0,0 +1,176 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Upload struct {
+	ID          uuid.UUID  `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	Offset      int64      `json:"upload_offset"`
+	CreateUploadParams
+}
+
+type CreateUploadParams struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	MediaType string    `json:"media_type"`
+	Length    int64     `json:"upload_length"`
+	Path      string    `json:"-"`
+}
+
+func (c Client) CreateUpload(params CreateUploadParams) (Upload, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO uploads (
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		media_type,
+		upload_length,
+		upload_offset,
+		path
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, 0, ?)
+	`
+	_, err := c.db.Exec(query, id, params.VideoID, params.UserID, params.MediaType, params.Length, params.Path)
+	if err != nil {
+		return Upload{}, err
+	}
+
+	return c.GetUpload(id)
+}
+
+const uploadColumns = `
+		uploads.id,
+		uploads.created_at,
+		uploads.updated_at,
+		uploads.completed_at,
+		uploads.video_id,
+		uploads.user_id,
+		uploads.media_type,
+		uploads.upload_length,
+		uploads.upload_offset,
+		uploads.path
+`
+
+func scanUpload(row interface{ Scan(...any) error }) (Upload, error) {
+	var upload Upload
+	err := row.Scan(
+		&upload.ID,
+		&upload.CreatedAt,
+		&upload.UpdatedAt,
+		&upload.CompletedAt,
+		&upload.VideoID,
+		&upload.UserID,
+		&upload.MediaType,
+		&upload.Length,
+		&upload.Offset,
+		&upload.Path,
+	)
+	return upload, err
+}
+
+func (c Client) GetUpload(id uuid.UUID) (Upload, error) {
+	query := `
+	SELECT ` + uploadColumns + `
+	FROM uploads
+	WHERE id = ?
+	`
+
+	upload, err := scanUpload(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Upload{}, nil
+		}
+		return Upload{}, err
+	}
+
+	return upload, nil
+}
+
+// GetAbandonedUploads lists uploads whose video is no longer waiting for
+// them: it expired, failed, was deleted, or got its file some other way.
+func (c Client) GetAbandonedUploads() ([]Upload, error) {
+	query := `
+	SELECT ` + uploadColumns + `
+	FROM uploads
+	LEFT JOIN videos ON videos.id = uploads.video_id
+	WHERE videos.id IS NULL OR videos.status != ?
+	`
+	rows, err := c.db.Query(query, VideoStatusUploading)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []Upload{}
+	for rows.Next() {
+		upload, err := scanUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+func (c Client) DeleteUpload(id uuid.UUID) error {
+	query := `
+	DELETE FROM uploads
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
+
+func (c Client) UpdateUploadOffset(id uuid.UUID, offset int64) error {
+	query := `
+	UPDATE uploads
+	SET
+		upload_offset = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, offset, id)
+	return err
+}
+
+// ClaimUpload marks a fully received upload completed. It reports false when
+// the upload was already claimed or isn't complete, so only one caller ever
+// goes on to process it.
+func (c Client) ClaimUpload(id uuid.UUID) (bool, error) {
+	query := `
+	UPDATE uploads
+	SET
+		completed_at = CURRENT_TIMESTAMP,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND completed_at IS NULL AND upload_offset = upload_length
+	`
+	res, err := c.db.Exec(query, id)
+	if err != nil {
+		return false, err
+	}
+	claimed, err := res.RowsAffected()
+	return claimed == 1, err
+}
+
+// ReleaseUpload undoes ClaimUpload so completing can be retried.
+func (c Client) ReleaseUpload(id uuid.UUID) error {
+	query := `
+	UPDATE uploads
+	SET
+		completed_at = NULL,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
@@ -0,0 +1,176 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type VideoStatus string
+
+const (
+	VideoStatusDraft      VideoStatus = "draft"
+	VideoStatusUploading  VideoStatus = "uploading"
+	VideoStatusProcessing VideoStatus = "processing"
+	VideoStatusReady      VideoStatus = "ready"
+	VideoStatusFailed     VideoStatus = "failed"
+	VideoStatusDeleted    VideoStatus = "deleted"
+)
+
+var ErrInvalidTransition = errors.New("invalid video status transition")
+
+// videoTransitions lists the statuses each status may move to. A ready or
+// failed video can be uploaded again; deleted is terminal. An upload can be
+// restarted, e.g. when a presigned URL expired or a response was lost.
+var videoTransitions = map[VideoStatus][]VideoStatus{
+	VideoStatusDraft:      {VideoStatusUploading, VideoStatusProcessing, VideoStatusDeleted},
+	VideoStatusUploading:  {VideoStatusUploading, VideoStatusProcessing, VideoStatusFailed, VideoStatusDeleted},
+	VideoStatusProcessing: {VideoStatusReady, VideoStatusFailed, VideoStatusDeleted},
+	VideoStatusReady:      {VideoStatusUploading, VideoStatusProcessing, VideoStatusDeleted},
+	VideoStatusFailed:     {VideoStatusUploading, VideoStatusProcessing, VideoStatusDeleted},
+	VideoStatusDeleted:    {},
+}
+
+// VideoStatusInfo is the lifecycle part of a video: its current status, why
+// it failed if it did, and when it last entered each status.
+type VideoStatusInfo struct {
+	Status          VideoStatus `json:"status"`
+	FailureReason   *string     `json:"failure_reason"`
+	StatusChangedAt *time.Time  `json:"status_changed_at"`
+	UploadingAt     *time.Time  `json:"uploading_at"`
+	ProcessingAt    *time.Time  `json:"processing_at"`
+	ReadyAt         *time.Time  `json:"ready_at"`
+	FailedAt        *time.Time  `json:"failed_at"`
+	DeletedAt       *time.Time  `json:"deleted_at"`
+}
+
+func CanTransition(from, to VideoStatus) bool {
+	for _, allowed := range videoTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// SetVideoStatus moves a video to a new status, rejecting transitions the
+// state machine does not allow with ErrInvalidTransition. reason is stored
+// for failures and cleared otherwise.
+func (c Client) SetVideoStatus(id uuid.UUID, to VideoStatus, reason string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = setVideoStatusTx(tx, id, to, reason)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func setVideoStatusTx(tx *sql.Tx, id uuid.UUID, to VideoStatus, reason string) error {
+	var from VideoStatus
+	err := tx.QueryRow(`SELECT status FROM videos WHERE id = ?`, id).Scan(&from)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("video %s not found", id)
+		}
+		return err
+	}
+	if !CanTransition(from, to) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, to)
+	}
+
+	var failureReason *string
+	if to == VideoStatusFailed {
+		failureReason = &reason
+	}
+
+	// to is one of the constants above, so building the column name from it
+	// is safe.
+	query := fmt.Sprintf(`
+	UPDATE videos
+	SET
+		status = ?,
+		failure_reason = ?,
+		status_changed_at = ?,
+		%s_at = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`, to)
+	now := time.Now().UTC()
+	_, err = tx.Exec(query, to, failureReason, now, now, id)
+	return err
+}
+
+// ExpireStaleUploads fails videos that have been uploading since before
+// cutoff without any resumable upload making progress since then. It
+// returns how many were failed.
+func (c Client) ExpireStaleUploads(cutoff time.Time, reason string) (int64, error) {
+	query := `
+	UPDATE videos
+	SET
+		status = ?,
+		failure_reason = ?,
+		status_changed_at = ?,
+		failed_at = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE status = ? AND status_changed_at < ? AND NOT EXISTS (
+		SELECT 1 FROM uploads
+		WHERE uploads.video_id = videos.id
+			AND uploads.completed_at IS NULL
+			AND uploads.updated_at >= ?
+	)
+	`
+	now := time.Now().UTC()
+	cutoff = cutoff.UTC()
+	res, err := c.db.Exec(query, VideoStatusFailed, reason, now, now, VideoStatusUploading, cutoff, cutoff.Format(time.DateTime))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (c *Client) migrateVideoStatus() error {
+	added, err := c.addColumnIfMissing("videos", "status", "TEXT NOT NULL DEFAULT 'draft'")
+	if err != nil {
+		return err
+	}
+	columns := []string{
+		"failure_reason",
+		"status_changed_at",
+		"uploading_at",
+		"processing_at",
+		"ready_at",
+		"failed_at",
+		"deleted_at",
+	}
+	for _, column := range columns {
+		definition := "TIMESTAMP"
+		if column == "failure_reason" {
+			definition = "TEXT"
+		}
+		if _, err := c.addColumnIfMissing("videos", column, definition); err != nil {
+			return err
+		}
+	}
+
+	// Rows from before statuses existed: anything with a stored video was
+	// fully processed.
+	if added {
+		_, err = c.db.Exec(`
+		UPDATE videos
+		SET status = ?, status_changed_at = updated_at, ready_at = updated_at
+		WHERE video_url IS NOT NULL
+		`, VideoStatusReady)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
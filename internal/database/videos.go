@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"time"
 
@@ -9,11 +10,37 @@ import (
 )
 
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// Thumbnail is where the thumbnail's renditions live, nil until one is
+	// set.
+	Thumbnail *Thumbnail
+	// LegacyThumbnailURL is a full URL into the assets directory, where
+	// thumbnails were written before they moved into the video store.
+	LegacyThumbnailURL *string
+	// Storage is where the processed MP4 lives, nil until one is uploaded.
+	Storage *StorageRef
+	// HLSKey and DASHKey are the streaming manifests and PreviewTrackKey the
+	// WebVTT track of scrub bar sprite tiles, all stored next to the MP4 in
+	// Storage.Bucket.
+	HLSKey          *string
+	DASHKey         *string
+	PreviewTrackKey *string
+	// PreviewKey is a short silent looping clip for listings, also in
+	// Storage.Bucket.
+	PreviewKey *string
+	// OriginalFormat is the content type of the container ffprobe found in
+	// the upload, before it was converted to MP4.
+	OriginalFormat *string
+	// AspectRatio is the display width over height, and AspectBucket the
+	// shape class the video was filed under.
+	AspectRatio  *float64
+	AspectBucket *string
+	// MediaInfo lives in its own table and is attached by GetVideo and
+	// GetVideos; UpdateVideo ignores it.
+	MediaInfo *MediaInfo
+	VideoStatusInfo
 	CreateVideoParams
 }
 
@@ -23,23 +50,139 @@ type CreateVideoParams struct {
 	UserID      uuid.UUID `json:"user_id"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
 		title,
 		description,
 		thumbnail_url,
-		video_url,
-		user_id
+		storage_bucket,
+		storage_key,
+		storage_backend,
+		content_type,
+		user_id,
+		status,
+		failure_reason,
+		status_changed_at,
+		uploading_at,
+		processing_at,
+		ready_at,
+		failed_at,
+		deleted_at,
+		hls_key,
+		dash_key,
+		preview_track_key,
+		preview_key,
+		original_format,
+		aspect_ratio,
+		aspect_bucket,
+		thumbnail_bucket,
+		thumbnail_prefix,
+		thumbnail_renditions,
+		thumbnail_blurhash,
+		thumbnail_lqip
+`
+
+// videoColumnMigrations are columns added to videos after the table was first
+// created.
+var videoColumnMigrations = []struct {
+	name       string
+	definition string
+}{
+	{"original_format", "TEXT"},
+	{"aspect_ratio", "REAL"},
+	{"aspect_bucket", "TEXT"},
+	{"thumbnail_blurhash", "TEXT"},
+	{"thumbnail_lqip", "TEXT"},
+	{"storage_bucket", "TEXT"},
+	{"storage_key", "TEXT"},
+	{"storage_backend", "TEXT"},
+	{"content_type", "TEXT"},
+	{"hls_key", "TEXT"},
+	{"dash_key", "TEXT"},
+	{"preview_track_key", "TEXT"},
+	{"preview_key", "TEXT"},
+	{"thumbnail_bucket", "TEXT"},
+	{"thumbnail_prefix", "TEXT"},
+	{"thumbnail_renditions", "TEXT"},
+}
+
+func scanVideo(row interface{ Scan(...any) error }) (Video, error) {
+	var video Video
+	var bucket, key, backend, contentType sql.NullString
+	var thumbnailBucket, thumbnailPrefix, renditions, blurHash, lqip sql.NullString
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.LegacyThumbnailURL,
+		&bucket,
+		&key,
+		&backend,
+		&contentType,
+		&video.UserID,
+		&video.Status,
+		&video.FailureReason,
+		&video.StatusChangedAt,
+		&video.UploadingAt,
+		&video.ProcessingAt,
+		&video.ReadyAt,
+		&video.FailedAt,
+		&video.DeletedAt,
+		&video.HLSKey,
+		&video.DASHKey,
+		&video.PreviewTrackKey,
+		&video.PreviewKey,
+		&video.OriginalFormat,
+		&video.AspectRatio,
+		&video.AspectBucket,
+		&thumbnailBucket,
+		&thumbnailPrefix,
+		&renditions,
+		&blurHash,
+		&lqip,
+	)
+	if err != nil {
+		return Video{}, err
+	}
+	if key.Valid {
+		video.Storage = &StorageRef{
+			Backend:     backend.String,
+			Bucket:      bucket.String,
+			Key:         key.String,
+			ContentType: contentType.String,
+		}
+	}
+	if thumbnailPrefix.Valid {
+		video.Thumbnail = &Thumbnail{
+			Bucket:   thumbnailBucket.String,
+			Prefix:   thumbnailPrefix.String,
+			BlurHash: blurHash.String,
+			LQIP:     lqip.String,
+		}
+		if renditions.Valid {
+			err = json.Unmarshal([]byte(renditions.String), &video.Thumbnail.Renditions)
+			if err != nil {
+				return Video{}, err
+			}
+		}
+	}
+	return video, nil
+}
+
+// GetVideos lists a user's videos, leaving out deleted ones.
+func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT ` + videoColumns + `
 	FROM videos
-	WHERE user_id = ?
+	WHERE user_id = ? AND status != ?
 	ORDER BY created_at DESC
 	`
 
-	rows, err := c.db.Query(query, userID)
+	rows, err := c.db.Query(query, userID, VideoStatusDeleted)
 	if err != nil {
 		return nil, err
 	}
@@ -47,22 +190,54 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	mediaInfos, err := c.getMediaInfoForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range videos {
+		videos[i].MediaInfo = mediaInfos[videos[i].ID]
+	}
+
+	return videos, nil
+}
+
+// GetAllVideos lists every video that isn't deleted, across all users.
+// MediaInfo is not attached.
+func (c Client) GetAllVideos() ([]Video, error) {
+	query := `
+	SELECT ` + videoColumns + `
+	FROM videos
+	WHERE status != ?
+	`
+
+	rows, err := c.db.Query(query, VideoStatusDeleted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 	return videos, nil
 }
 
@@ -75,10 +250,12 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		user_id,
+		status,
+		status_changed_at
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, CURRENT_TIMESTAMP)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID, VideoStatusDraft)
 	if err != nil {
 		return Video{}, err
 	}
@@ -88,29 +265,12 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT ` + videoColumns + `
 	FROM videos
 	WHERE id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -118,18 +278,63 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 		return Video{}, err
 	}
 
+	video.MediaInfo, err = c.GetMediaInfo(id)
+	if err != nil {
+		return Video{}, err
+	}
+
 	return video, nil
 }
 
+// UpdateVideo saves the editable fields of a video. The status is only ever
+// changed through SetVideoStatus so transitions stay enforced.
 func (c Client) UpdateVideo(video Video) error {
+	var bucket, key, backend, contentType *string
+	if video.Storage != nil {
+		bucket = &video.Storage.Bucket
+		key = &video.Storage.Key
+		backend = &video.Storage.Backend
+		contentType = &video.Storage.ContentType
+	}
+
+	var thumbnailBucket, thumbnailPrefix, renditions, blurHash, lqip *string
+	if video.Thumbnail != nil {
+		data, err := json.Marshal(video.Thumbnail.Renditions)
+		if err != nil {
+			return err
+		}
+		s := string(data)
+		renditions = &s
+		thumbnailBucket = &video.Thumbnail.Bucket
+		thumbnailPrefix = &video.Thumbnail.Prefix
+		blurHash = &video.Thumbnail.BlurHash
+		lqip = &video.Thumbnail.LQIP
+	}
+
 	query := `
 	UPDATE videos
 	SET
 		title = ?,
 		description = ?,
 		thumbnail_url = ?,
-		video_url = ?,
-		user_id = ?
+		storage_bucket = ?,
+		storage_key = ?,
+		storage_backend = ?,
+		content_type = ?,
+		hls_key = ?,
+		dash_key = ?,
+		preview_track_key = ?,
+		preview_key = ?,
+		original_format = ?,
+		aspect_ratio = ?,
+		aspect_bucket = ?,
+		thumbnail_bucket = ?,
+		thumbnail_prefix = ?,
+		thumbnail_renditions = ?,
+		thumbnail_blurhash = ?,
+		thumbnail_lqip = ?,
+		user_id = ?,
+		updated_at = CURRENT_TIMESTAMP
 	WHERE id = ?
 	`
 
@@ -137,19 +342,196 @@ func (c Client) UpdateVideo(video Video) error {
 		query,
 		video.Title,
 		video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
+		&video.LegacyThumbnailURL,
+		bucket,
+		key,
+		backend,
+		contentType,
+		&video.HLSKey,
+		&video.DASHKey,
+		&video.PreviewTrackKey,
+		&video.PreviewKey,
+		&video.OriginalFormat,
+		&video.AspectRatio,
+		&video.AspectBucket,
+		thumbnailBucket,
+		thumbnailPrefix,
+		renditions,
+		blurHash,
+		lqip,
 		video.UserID,
 		video.ID,
 	)
 	return err
 }
 
-func (c Client) DeleteVideo(id uuid.UUID) error {
+// UpdateVideoMedia saves what processing produced: the stored MP4, its
+// streaming packages and previews, and what was learned about the upload.
+// Columns the owner edits meanwhile, the thumbnail among them, are left alone.
+func (c Client) UpdateVideoMedia(video Video) error {
+	var bucket, key, backend, contentType *string
+	if video.Storage != nil {
+		bucket = &video.Storage.Bucket
+		key = &video.Storage.Key
+		backend = &video.Storage.Backend
+		contentType = &video.Storage.ContentType
+	}
+
 	query := `
-	DELETE FROM videos
+	UPDATE videos
+	SET
+		storage_bucket = ?,
+		storage_key = ?,
+		storage_backend = ?,
+		content_type = ?,
+		hls_key = ?,
+		dash_key = ?,
+		preview_track_key = ?,
+		preview_key = ?,
+		original_format = ?,
+		aspect_ratio = ?,
+		aspect_bucket = ?,
+		updated_at = CURRENT_TIMESTAMP
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
+
+	_, err := c.db.Exec(
+		query,
+		bucket,
+		key,
+		backend,
+		contentType,
+		&video.HLSKey,
+		&video.DASHKey,
+		&video.PreviewTrackKey,
+		&video.PreviewKey,
+		&video.OriginalFormat,
+		&video.AspectRatio,
+		&video.AspectBucket,
+		video.ID,
+	)
 	return err
 }
+
+// SetVideoThumbnail points a video at a new thumbnail, replacing any legacy
+// thumbnail URL, and returns the thumbnail it replaced so the caller can
+// remove exactly those renditions.
+func (c Client) SetVideoThumbnail(id uuid.UUID, thumbnail Thumbnail) (*Thumbnail, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var bucket, prefix, renditions sql.NullString
+	err = tx.QueryRow(`
+	SELECT thumbnail_bucket, thumbnail_prefix, thumbnail_renditions
+	FROM videos
+	WHERE id = ?
+	`, id).Scan(&bucket, &prefix, &renditions)
+	if err != nil {
+		return nil, err
+	}
+	var previous *Thumbnail
+	if prefix.Valid {
+		previous = &Thumbnail{
+			Bucket: bucket.String,
+			Prefix: prefix.String,
+		}
+		if renditions.Valid {
+			err = json.Unmarshal([]byte(renditions.String), &previous.Renditions)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	_, err = setVideoThumbnail(tx, id, thumbnail, "")
+	if err != nil {
+		return nil, err
+	}
+	return previous, tx.Commit()
+}
+
+// SetDefaultVideoThumbnail sets the thumbnail only if the video has none, so
+// a generated one never replaces one the owner chose. It reports whether the
+// thumbnail was set.
+func (c Client) SetDefaultVideoThumbnail(id uuid.UUID, thumbnail Thumbnail) (bool, error) {
+	return setVideoThumbnail(c.db, id, thumbnail, "AND thumbnail_prefix IS NULL AND thumbnail_url IS NULL")
+}
+
+// setVideoThumbnail runs on the client or inside a transaction. condition
+// is appended to the WHERE clause.
+func setVideoThumbnail(db interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}, id uuid.UUID, thumbnail Thumbnail, condition string) (bool, error) {
+	renditions, err := json.Marshal(thumbnail.Renditions)
+	if err != nil {
+		return false, err
+	}
+
+	query := `
+	UPDATE videos
+	SET
+		thumbnail_url = NULL,
+		thumbnail_bucket = ?,
+		thumbnail_prefix = ?,
+		thumbnail_renditions = ?,
+		thumbnail_blurhash = ?,
+		thumbnail_lqip = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? ` + condition
+
+	res, err := db.Exec(
+		query,
+		thumbnail.Bucket,
+		thumbnail.Prefix,
+		string(renditions),
+		thumbnail.BlurHash,
+		thumbnail.LQIP,
+		id,
+	)
+	if err != nil {
+		return false, err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return updated > 0, nil
+}
+
+// DeleteVideo marks a video deleted and records the objects to remove in the
+// same transaction, so a crash can't lose track of them.
+func (c Client) DeleteVideo(id uuid.UUID, deletions []CreateStorageDeletionParams) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = setVideoStatusTx(tx, id, VideoStatusDeleted, "")
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO storage_deletions (
+		id,
+		created_at,
+		video_id,
+		store,
+		key,
+		attempts,
+		run_at
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, 0, ?)
+	`
+	now := time.Now().UTC()
+	for _, deletion := range deletions {
+		_, err = tx.Exec(query, uuid.New(), deletion.VideoID, deletion.Store, deletion.Key, now)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
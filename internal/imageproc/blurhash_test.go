@@ -0,0 +1,40 @@
+package imageproc
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestBlurHash(t *testing.T) {
+	// A 6x4 gradient, small enough to be encoded without shrinking. The
+	// expected hashes come from a separate port of the reference encoder
+	// at https://github.com/woltapp/blurhash.
+	gradient := image.NewRGBA(image.Rect(0, 0, 6, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 6; x++ {
+			gradient.SetRGBA(x, y, color.RGBA{uint8(x * 40), uint8(y * 60), uint8(200 - x*20 - y*10), 255})
+		}
+	}
+	// All black has no AC energy at all, whatever the size.
+	black := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for i := 3; i < len(black.Pix); i += 4 {
+		black.Pix[i] = 255
+	}
+
+	tests := []struct {
+		name                     string
+		img                      image.Image
+		xComponents, yComponents int
+		want                     string
+	}{
+		{"gradient 4x3", gradient, 4, 3, "LiEL]o7jSR%4*[NOWsrxeIe?fRe?"},
+		{"gradient 1x1", gradient, 1, 1, "00EL]o"},
+		{"black 4x3", black, 4, 3, "L00000fQfQfQfQfQfQfQfQfQfQfQ"},
+	}
+	for _, tt := range tests {
+		if got := BlurHash(tt.img, tt.xComponents, tt.yComponents); got != tt.want {
+			t.Errorf("%s: BlurHash = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
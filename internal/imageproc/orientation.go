@@ -0,0 +1,142 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+)
+
+// Orientation returns the EXIF orientation of a JPEG or PNG, 1 through 8,
+// or 1 when the image carries none.
+func Orientation(data []byte) int {
+	var exif []byte
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		exif = jpegExif(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		exif = pngExif(data)
+	}
+	if o := exifOrientation(exif); o >= 1 && o <= 8 {
+		return o
+	}
+	return 1
+}
+
+// jpegExif returns the TIFF payload of the APP1 Exif segment.
+func jpegExif(data []byte) []byte {
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xff {
+			return nil
+		}
+		marker := data[i+1]
+		// Start of scan: the metadata segments are all behind us.
+		if marker == 0xda || marker == 0xd9 {
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return nil
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		i += 2 + length
+	}
+	return nil
+}
+
+// pngExif returns the contents of the eXIf chunk.
+func pngExif(data []byte) []byte {
+	i := 8
+	for i+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		chunkType := string(data[i+4 : i+8])
+		if length < 0 || i+12+length > len(data) {
+			return nil
+		}
+		if chunkType == "eXIf" {
+			return data[i+8 : i+8+length]
+		}
+		if chunkType == "IDAT" || chunkType == "IEND" {
+			return nil
+		}
+		i += 12 + length
+	}
+	return nil
+}
+
+// exifOrientation reads tag 0x0112 from IFD0 of a TIFF structure.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[offset:]))
+	for n := 0; n < entries; n++ {
+		entry := offset + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// ApplyOrientation turns img the way its EXIF orientation says a viewer
+// should display it, so the result looks right once the metadata is gone.
+// An upright *image.RGBA is returned as is.
+func ApplyOrientation(img image.Image, orientation int) *image.RGBA {
+	src := ToRGBA(img)
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+
+	// Orientations 5 through 8 swap the axes.
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // upside down
+				dx, dy = w-1-x, h-1-y
+			case 4: // upside down, mirrored
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90° counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+	return dst
+}
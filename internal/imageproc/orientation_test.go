@@ -0,0 +1,100 @@
+package imageproc
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The fixtures in testdata are written by gen.go. Displayed the way their
+// orientation says, each is 192x128 with red, green, blue and yellow
+// quadrants clockwise from the top left.
+var fixtureQuadrants = [2][2]color.RGBA{
+	{{255, 0, 0, 255}, {0, 255, 0, 255}},
+	{{0, 0, 255, 255}, {255, 255, 0, 255}},
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestOrientation(t *testing.T) {
+	for orientation := 1; orientation <= 8; orientation++ {
+		for _, ext := range []string{"jpg", "png"} {
+			name := fmt.Sprintf("orientation_%d.%s", orientation, ext)
+			if got := Orientation(readFixture(t, name)); got != orientation {
+				t.Errorf("Orientation(%s) = %d, want %d", name, got, orientation)
+			}
+		}
+	}
+}
+
+func TestOrientationWithoutExif(t *testing.T) {
+	data := readFixture(t, "orientation_6.jpg")
+	tests := map[string][]byte{
+		"empty":          nil,
+		"not an image":   []byte("GIF89a"),
+		"bare jpeg":      {0xff, 0xd8, 0xff, 0xd9},
+		"truncated jpeg": data[:30],
+	}
+	for name, data := range tests {
+		if got := Orientation(data); got != 1 {
+			t.Errorf("%s: Orientation = %d, want 1", name, got)
+		}
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	for orientation := 1; orientation <= 8; orientation++ {
+		for _, ext := range []string{"jpg", "png"} {
+			name := fmt.Sprintf("orientation_%d.%s", orientation, ext)
+			data := readFixture(t, name)
+			img, _, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			checkUpright(t, name, ApplyOrientation(img, Orientation(data)))
+		}
+	}
+}
+
+// checkUpright samples the middle of each quadrant, allowing for JPEG's
+// losses.
+func checkUpright(t *testing.T, name string, img image.Image) {
+	t.Helper()
+	b := img.Bounds()
+	if b.Dx() != 192 || b.Dy() != 128 {
+		t.Errorf("%s: size %dx%d, want 192x128", name, b.Dx(), b.Dy())
+		return
+	}
+	for row := 0; row < 2; row++ {
+		for col := 0; col < 2; col++ {
+			x := b.Min.X + b.Dx()*(2*col+1)/4
+			y := b.Min.Y + b.Dy()*(2*row+1)/4
+			got := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			want := fixtureQuadrants[row][col]
+			if !closeColor(got, want) {
+				t.Errorf("%s: pixel at (%d, %d) is %v, want %v", name, x, y, got, want)
+			}
+		}
+	}
+}
+
+func closeColor(a, b color.RGBA) bool {
+	near := func(x, y uint8) bool {
+		d := int(x) - int(y)
+		return d > -32 && d < 32
+	}
+	return near(a.R, b.R) && near(a.G, b.G) && near(a.B, b.B)
+}
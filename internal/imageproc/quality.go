@@ -0,0 +1,102 @@
+package imageproc
+
+import (
+	"image"
+	"math"
+)
+
+// Quality holds cheap measurements used to pick a decent frame: brightness and
+// contrast are 0..1, sharpness is the variance of the Laplacian, which drops
+// sharply for blurry images.
+type Quality struct {
+	Brightness float64 `json:"brightness"`
+	Contrast   float64 `json:"contrast"`
+	Sharpness  float64 `json:"sharpness"`
+}
+
+// measureWidth is the width images are sampled down to before measuring, so
+// the result doesn't depend on resolution and stays fast.
+const measureWidth = 256
+
+func Measure(img image.Image) Quality {
+	gray := sampleGray(img, measureWidth)
+	h := len(gray)
+	if h == 0 {
+		return Quality{}
+	}
+	w := len(gray[0])
+
+	var sum, sumSq float64
+	for _, row := range gray {
+		for _, v := range row {
+			sum += v
+			sumSq += v * v
+		}
+	}
+	n := float64(w * h)
+	mean := sum / n
+	variance := sumSq/n - mean*mean
+
+	var lapSum, lapSumSq float64
+	lapN := 0.0
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			lap := gray[y-1][x] + gray[y+1][x] + gray[y][x-1] + gray[y][x+1] - 4*gray[y][x]
+			lapSum += lap
+			lapSumSq += lap * lap
+			lapN++
+		}
+	}
+	sharpness := 0.0
+	if lapN > 0 {
+		lapMean := lapSum / lapN
+		sharpness = lapSumSq/lapN - lapMean*lapMean
+	}
+
+	return Quality{
+		Brightness: mean / 255,
+		Contrast:   math.Sqrt(math.Max(variance, 0)) / 255,
+		Sharpness:  sharpness,
+	}
+}
+
+// Score ranks frames for use as a thumbnail. Near-black, washed out and flat
+// frames (fades, title cards) score zero; otherwise sharper frames with
+// mid-range exposure win.
+func (q Quality) Score() float64 {
+	if q.Brightness < 0.06 || q.Brightness > 0.96 || q.Contrast < 0.03 {
+		return 0
+	}
+	exposure := 1 - math.Abs(q.Brightness-0.5)
+	return q.Sharpness * exposure
+}
+
+// sampleGray returns the luma of img, nearest-neighbour sampled to at most
+// maxWidth columns.
+func sampleGray(img image.Image, maxWidth int) [][]float64 {
+	b := img.Bounds()
+	if b.Dx() <= 0 || b.Dy() <= 0 {
+		return nil
+	}
+	w := b.Dx()
+	h := b.Dy()
+	if w > maxWidth {
+		h = h * maxWidth / w
+		w = maxWidth
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	gray := make([][]float64, h)
+	for y := 0; y < h; y++ {
+		gray[y] = make([]float64, w)
+		sy := b.Min.Y + y*b.Dy()/h
+		for x := 0; x < w; x++ {
+			sx := b.Min.X + x*b.Dx()/w
+			r, g, bl, _ := img.At(sx, sy).RGBA()
+			gray[y][x] = (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)) / 257
+		}
+	}
+	return gray
+}
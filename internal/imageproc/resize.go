@@ -0,0 +1,73 @@
+package imageproc
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Resize scales img to width, keeping its aspect ratio. Each output pixel
+// averages the source pixels it covers, which holds up far better than
+// nearest neighbour when shrinking a lot. Images are never enlarged.
+func Resize(img image.Image, width int) *image.RGBA {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if width <= 0 || width > sw {
+		width = sw
+	}
+	height := max(1, int(float64(sh)*float64(width)/float64(sw)+0.5))
+
+	src := ToRGBA(img)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		sy0 := y * sh / height
+		sy1 := max((y+1)*sh/height, sy0+1)
+		for x := 0; x < width; x++ {
+			sx0 := x * sw / width
+			sx1 := max((x+1)*sw/width, sx0+1)
+
+			var r, g, bl, a, n uint32
+			for sy := sy0; sy < sy1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := sx0; sx < sx1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += uint32(p[0])
+					g += uint32(p[1])
+					bl += uint32(p[2])
+					a += uint32(p[3])
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(bl / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// ToRGBA returns img as an *image.RGBA at the origin, converting it only if
+// it isn't one already. Callers producing several outputs from one source
+// convert it once and pass the result on.
+func ToRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	if rgba, ok := img.(*image.RGBA); ok && b.Min == (image.Point{}) {
+		return rgba
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
+
+// Flatten draws img over a solid background, for formats like JPEG that
+// have no alpha channel.
+func Flatten(img image.Image, background color.Color) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Over)
+	return dst
+}
@@ -0,0 +1,162 @@
+//go:build ignore
+
+// gen writes the orientation fixtures: a JPEG and a PNG for each EXIF
+// orientation, whose pixels are stored so that a viewer honouring the tag
+// shows red, green, blue and yellow quadrants clockwise from the top left.
+// Each also carries EXIF, XMP and ICC blocks, which must never reach the
+// stored thumbnails.
+//
+//	go run gen.go
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"os"
+)
+
+// The upright image as it should be displayed.
+const (
+	displayWidth  = 192
+	displayHeight = 128
+)
+
+var quadrants = [2][2]color.RGBA{
+	{{255, 0, 0, 255}, {0, 255, 0, 255}},
+	{{0, 0, 255, 255}, {255, 255, 0, 255}},
+}
+
+const xmp = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+	`<rdf:Description xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:CreatorTool="tubely fixture"/></rdf:RDF></x:xmpmeta>`
+
+func main() {
+	for orientation := 1; orientation <= 8; orientation++ {
+		img := stored(orientation)
+
+		var jpegData bytes.Buffer
+		if err := jpeg.Encode(&jpegData, img, &jpeg.Options{Quality: 95}); err != nil {
+			log.Fatal(err)
+		}
+		write(fmt.Sprintf("orientation_%d.jpg", orientation), withJPEGMetadata(jpegData.Bytes(), orientation))
+
+		var pngData bytes.Buffer
+		if err := png.Encode(&pngData, img); err != nil {
+			log.Fatal(err)
+		}
+		write(fmt.Sprintf("orientation_%d.png", orientation), withPNGMetadata(pngData.Bytes(), orientation))
+	}
+}
+
+// stored lays the pixels out the way a camera holding the sensor at the
+// given orientation would, following where the EXIF specification puts
+// the stored row 0 and column 0 on the displayed image.
+func stored(orientation int) image.Image {
+	w, h := displayWidth, displayHeight
+	if orientation >= 5 {
+		w, h = h, w
+	}
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 1: // row 0 top, column 0 left
+				dx, dy = x, y
+			case 2: // row 0 top, column 0 right
+				dx, dy = w-1-x, y
+			case 3: // row 0 bottom, column 0 right
+				dx, dy = w-1-x, h-1-y
+			case 4: // row 0 bottom, column 0 left
+				dx, dy = x, h-1-y
+			case 5: // row 0 left, column 0 top
+				dx, dy = y, x
+			case 6: // row 0 right, column 0 top
+				dx, dy = h-1-y, x
+			case 7: // row 0 right, column 0 bottom
+				dx, dy = h-1-y, w-1-x
+			case 8: // row 0 left, column 0 bottom
+				dx, dy = y, w-1-x
+			}
+			img.SetRGBA(x, y, quadrants[dy*2/displayHeight][dx*2/displayWidth])
+		}
+	}
+	return img
+}
+
+// tiff builds an EXIF IFD0 with a camera make and the orientation.
+func tiff(order binary.ByteOrder, orientation int) []byte {
+	var b bytes.Buffer
+	if order == binary.BigEndian {
+		b.WriteString("MM")
+	} else {
+		b.WriteString("II")
+	}
+	binary.Write(&b, order, uint16(42))
+	binary.Write(&b, order, uint32(8))
+	binary.Write(&b, order, uint16(2))
+	// Make, ASCII, 4 bytes inline.
+	binary.Write(&b, order, []uint16{0x010f, 2})
+	binary.Write(&b, order, uint32(4))
+	b.WriteString("Cam\x00")
+	// Orientation, SHORT.
+	binary.Write(&b, order, []uint16{0x0112, 3})
+	binary.Write(&b, order, uint32(1))
+	binary.Write(&b, order, []uint16{uint16(orientation), 0})
+	binary.Write(&b, order, uint32(0))
+	return b.Bytes()
+}
+
+// iccProfile is a stand-in profile. Decoders never look inside it.
+func iccProfile() []byte {
+	return bytes.Repeat([]byte("ICC profile data "), 8)
+}
+
+func withJPEGMetadata(data []byte, orientation int) []byte {
+	segment := func(marker byte, payload []byte) []byte {
+		s := []byte{0xff, marker, 0, 0}
+		binary.BigEndian.PutUint16(s[2:], uint16(len(payload)+2))
+		return append(s, payload...)
+	}
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment(0xe1, append([]byte("Exif\x00\x00"), tiff(binary.BigEndian, orientation)...))...)
+	out = append(out, segment(0xe1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), xmp...))...)
+	out = append(out, segment(0xe2, append([]byte("ICC_PROFILE\x00\x01\x01"), iccProfile()...))...)
+	return append(out, data[2:]...)
+}
+
+func withPNGMetadata(data []byte, orientation int) []byte {
+	chunk := func(chunkType string, payload []byte) []byte {
+		c := make([]byte, 4, 12+len(payload))
+		binary.BigEndian.PutUint32(c, uint32(len(payload)))
+		c = append(c, chunkType...)
+		c = append(c, payload...)
+		return binary.BigEndian.AppendUint32(c, crc32.ChecksumIEEE(c[4:]))
+	}
+
+	var profile bytes.Buffer
+	zw := zlib.NewWriter(&profile)
+	zw.Write(iccProfile())
+	zw.Close()
+
+	// The signature and IHDR come first, the metadata goes right after.
+	const header = 8 + 12 + 13
+	out := append([]byte{}, data[:header]...)
+	out = append(out, chunk("iCCP", append([]byte("fixture\x00\x00"), profile.Bytes()...))...)
+	out = append(out, chunk("eXIf", tiff(binary.LittleEndian, orientation))...)
+	out = append(out, chunk("iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), xmp...))...)
+	return append(out, data[header:]...)
+}
+
+func write(name string, data []byte) {
+	if err := os.WriteFile(name, data, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,191 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Handler runs a single attempt of a job. Returning an error schedules a
+// retry until the job runs out of attempts.
+type Handler func(ctx context.Context, job database.Job) error
+
+type Options struct {
+	Workers      int
+	MaxAttempts  int
+	PollInterval time.Duration
+	// BaseBackoff is the delay before the first retry, doubled for every
+	// further attempt and capped at MaxBackoff.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+// Queue is a job queue persisted in the jobs table, so queued work survives
+// restarts.
+type Queue struct {
+	db       database.Client
+	opts     Options
+	handlers map[string]Handler
+	wake     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func NewQueue(db database.Client, opts Options) *Queue {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 1
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 2 * time.Second
+	}
+	if opts.BaseBackoff <= 0 {
+		opts.BaseBackoff = 10 * time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 10 * time.Minute
+	}
+	return &Queue{
+		db:       db,
+		opts:     opts,
+		handlers: map[string]Handler{},
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Register must be called before Start.
+func (q *Queue) Register(jobType string, handler Handler) {
+	q.handlers[jobType] = handler
+}
+
+func (q *Queue) Enqueue(jobType string, videoID, userID *uuid.UUID, payload any) (database.Job, error) {
+	if _, ok := q.handlers[jobType]; !ok {
+		return database.Job{}, fmt.Errorf("no handler registered for job type %q", jobType)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return database.Job{}, err
+	}
+	job, err := q.db.CreateJob(database.CreateJobParams{
+		Type:        jobType,
+		Payload:     string(data),
+		MaxAttempts: q.opts.MaxAttempts,
+		VideoID:     videoID,
+		UserID:      userID,
+	})
+	if err != nil {
+		return database.Job{}, err
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// Start requeues jobs interrupted by a previous shutdown and launches the
+// workers. They stop when ctx is cancelled; Wait blocks until they have.
+func (q *Queue) Start(ctx context.Context) error {
+	requeued, err := q.db.RequeueRunningJobs()
+	if err != nil {
+		return err
+	}
+	if requeued > 0 {
+		log.Printf("Requeued %d interrupted jobs", requeued)
+	}
+
+	for i := 0; i < q.opts.Workers; i++ {
+		q.wg.Add(1)
+		go q.work(ctx)
+	}
+	return nil
+}
+
+func (q *Queue) Wait() {
+	q.wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	defer q.wg.Done()
+	ticker := time.NewTicker(q.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for q.runNext(ctx) {
+			if ctx.Err() != nil {
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// runNext claims and runs one job, reporting whether there was one.
+func (q *Queue) runNext(ctx context.Context) bool {
+	job, ok, err := q.db.ClaimNextJob()
+	if err != nil {
+		log.Printf("Couldn't claim job: %v", err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+
+	handler, found := q.handlers[job.Type]
+	if !found {
+		q.finish(job, fmt.Errorf("no handler registered for job type %q", job.Type), false)
+		return true
+	}
+
+	err = q.run(ctx, handler, job)
+	if ctx.Err() != nil {
+		// Shutting down: leave the job running so the next Start requeues it
+		// instead of burning one of its attempts.
+		return false
+	}
+	q.finish(job, err, true)
+	return true
+}
+
+func (q *Queue) run(ctx context.Context, handler Handler, job database.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+func (q *Queue) finish(job database.Job, jobErr error, retryable bool) {
+	var err error
+	switch {
+	case jobErr == nil:
+		err = q.db.CompleteJob(job.ID)
+	case retryable && job.Attempts < job.MaxAttempts:
+		backoff := q.opts.BaseBackoff << (job.Attempts - 1)
+		if backoff > q.opts.MaxBackoff || backoff <= 0 {
+			backoff = q.opts.MaxBackoff
+		}
+		log.Printf("Job %s (%s) attempt %d failed, retrying in %s: %v", job.ID, job.Type, job.Attempts, backoff, jobErr)
+		err = q.db.RetryJob(job.ID, jobErr.Error(), time.Now().Add(backoff))
+	default:
+		log.Printf("Job %s (%s) failed after %d attempts: %v", job.ID, job.Type, job.Attempts, jobErr)
+		err = q.db.FailJob(job.ID, jobErr.Error())
+	}
+	if err != nil {
+		log.Printf("Couldn't record result of job %s: %v", job.ID, err)
+	}
+}
@@ -0,0 +1,243 @@
+package mediaprobe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FFprobePath is the ffprobe binary Probe runs.
+var FFprobePath = "ffprobe"
+
+type Result struct {
+	FormatName string `json:"format_name"`
+	// MajorBrand is the ISO media brand, e.g. "qt  " for QuickTime or
+	// "isom" for MP4. ffprobe names both formats "mov,mp4,...", so this is
+	// what tells them apart.
+	MajorBrand string        `json:"major_brand,omitempty"`
+	Duration   time.Duration `json:"-"`
+	BitRate    int64         `json:"bit_rate"`
+	Size       int64         `json:"size"`
+	Streams    []Stream      `json:"streams"`
+}
+
+type Stream struct {
+	Index     int    `json:"index"`
+	CodecType string `json:"codec_type"`
+	CodecName string `json:"codec_name"`
+	Profile   string `json:"profile,omitempty"`
+	BitRate   int64  `json:"bit_rate,omitempty"`
+
+	// Video
+	Width             int     `json:"width,omitempty"`
+	Height            int     `json:"height,omitempty"`
+	FrameRate         float64 `json:"frame_rate,omitempty"`
+	Rotation          int     `json:"rotation,omitempty"`
+	SampleAspectRatio string  `json:"sample_aspect_ratio,omitempty"`
+	PixelFormat       string  `json:"pixel_format,omitempty"`
+	// AttachedPicture marks cover art, which ffprobe lists as a one-frame
+	// video stream.
+	AttachedPicture bool `json:"attached_picture,omitempty"`
+
+	// Audio
+	ChannelLayout string `json:"channel_layout,omitempty"`
+	Channels      int    `json:"channels,omitempty"`
+	SampleRate    int    `json:"sample_rate,omitempty"`
+}
+
+func (r Result) FirstVideo() (Stream, bool) {
+	return r.first("video")
+}
+
+func (r Result) FirstAudio() (Stream, bool) {
+	return r.first("audio")
+}
+
+func (r Result) first(codecType string) (Stream, bool) {
+	for _, s := range r.Streams {
+		if s.CodecType == codecType && !s.AttachedPicture {
+			return s, true
+		}
+	}
+	return Stream{}, false
+}
+
+// DisplaySize is the size a player shows the stream at: the coded size
+// stretched by the sample aspect ratio, then turned by the rotation.
+func (s Stream) DisplaySize() (width, height int) {
+	width, height = s.Width, s.Height
+	num, den, ok := strings.Cut(s.SampleAspectRatio, ":")
+	if ok {
+		n, err1 := strconv.Atoi(num)
+		d, err2 := strconv.Atoi(den)
+		// 0:1 and N/A mean the sample aspect ratio is unknown, taken as square.
+		if err1 == nil && err2 == nil && n > 0 && d > 0 && n != d {
+			width = int(math.Round(float64(width) * float64(n) / float64(d)))
+		}
+	}
+	if s.Rotation == 90 || s.Rotation == 270 {
+		width, height = height, width
+	}
+	return width, height
+}
+
+// ffprobe reports most numbers as strings, so the raw output is decoded into
+// these and converted.
+type rawOutput struct {
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+		Size       string `json:"size"`
+		Tags       struct {
+			MajorBrand string `json:"major_brand"`
+		} `json:"tags"`
+	} `json:"format"`
+	Streams []rawStream `json:"streams"`
+}
+
+type rawStream struct {
+	Index             int    `json:"index"`
+	CodecType         string `json:"codec_type"`
+	CodecName         string `json:"codec_name"`
+	Profile           string `json:"profile"`
+	BitRate           string `json:"bit_rate"`
+	Width             int    `json:"width"`
+	Height            int    `json:"height"`
+	AvgFrameRate      string `json:"avg_frame_rate"`
+	RFrameRate        string `json:"r_frame_rate"`
+	SampleAspectRatio string `json:"sample_aspect_ratio"`
+	PixFmt            string `json:"pix_fmt"`
+	ChannelLayout     string `json:"channel_layout"`
+	Channels          int    `json:"channels"`
+	SampleRate        string `json:"sample_rate"`
+	Tags              struct {
+		Rotate string `json:"rotate"`
+	} `json:"tags"`
+	SideDataList []struct {
+		SideDataType string  `json:"side_data_type"`
+		Rotation     float64 `json:"rotation"`
+	} `json:"side_data_list"`
+	Disposition struct {
+		AttachedPic int `json:"attached_pic"`
+	} `json:"disposition"`
+}
+
+// Probe runs ffprobe on path and returns the container and stream details.
+func Probe(ctx context.Context, path string) (Result, error) {
+	cmd := exec.CommandContext(ctx, FFprobePath,
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		path,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return Result{}, fmt.Errorf("ffprobe: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return Parse(output)
+}
+
+// Parse decodes the JSON ffprobe prints with -show_format -show_streams.
+func Parse(output []byte) (Result, error) {
+	var raw rawOutput
+	if err := json.Unmarshal(output, &raw); err != nil {
+		return Result{}, fmt.Errorf("couldn't decode ffprobe output: %w", err)
+	}
+
+	result := Result{
+		FormatName: raw.Format.FormatName,
+		MajorBrand: raw.Format.Tags.MajorBrand,
+		Duration:   parseSeconds(raw.Format.Duration),
+		BitRate:    parseInt(raw.Format.BitRate),
+		Size:       parseInt(raw.Format.Size),
+	}
+	for _, rs := range raw.Streams {
+		frameRate := parseRatio(rs.AvgFrameRate)
+		if frameRate == 0 {
+			frameRate = parseRatio(rs.RFrameRate)
+		}
+		stream := Stream{
+			Index:             rs.Index,
+			CodecType:         rs.CodecType,
+			CodecName:         rs.CodecName,
+			Profile:           rs.Profile,
+			BitRate:           parseInt(rs.BitRate),
+			Width:             rs.Width,
+			Height:            rs.Height,
+			FrameRate:         math.Round(frameRate*1000) / 1000,
+			Rotation:          rotation(rs),
+			SampleAspectRatio: rs.SampleAspectRatio,
+			PixelFormat:       rs.PixFmt,
+			ChannelLayout:     rs.ChannelLayout,
+			Channels:          rs.Channels,
+			SampleRate:        int(parseInt(rs.SampleRate)),
+			AttachedPicture:   rs.Disposition.AttachedPic == 1,
+		}
+		result.Streams = append(result.Streams, stream)
+	}
+	return result, nil
+}
+
+// rotation returns the clockwise rotation a player applies, normalized to
+// 0, 90, 180 or 270. Newer ffmpeg versions report it as display matrix side
+// data (counter-clockwise, hence the sign flip), older ones as a rotate tag.
+func rotation(rs rawStream) int {
+	degrees := 0
+	found := false
+	for _, sd := range rs.SideDataList {
+		if sd.SideDataType == "Display Matrix" {
+			degrees = -int(math.Round(sd.Rotation))
+			found = true
+			break
+		}
+	}
+	if !found && rs.Tags.Rotate != "" {
+		degrees = int(parseInt(rs.Tags.Rotate))
+	}
+	degrees %= 360
+	if degrees < 0 {
+		degrees += 360
+	}
+	return degrees
+}
+
+func parseInt(s string) int64 {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+func parseSeconds(s string) time.Duration {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(f * float64(time.Second))
+}
+
+// parseRatio parses ffprobe's "num/den" rates such as "30000/1001".
+func parseRatio(s string) float64 {
+	num, den, ok := strings.Cut(s, "/")
+	if !ok {
+		f, _ := strconv.ParseFloat(s, 64)
+		return f
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
@@ -0,0 +1,148 @@
+package mediaprobe
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The files in testdata are ffprobe -print_format json -show_format
+// -show_streams output.
+func TestParse(t *testing.T) {
+	tests := []struct {
+		file          string
+		formatName    string
+		majorBrand    string
+		duration      time.Duration
+		bitRate       int64
+		videoIndex    int
+		videoBitRate  int64
+		frameRate     float64
+		rotation      int
+		displayWidth  int
+		displayHeight int
+		audioIndex    int
+	}{
+		{
+			file:          "audio_first.json",
+			formatName:    "mov,mp4,m4a,3gp,3g2,mj2",
+			majorBrand:    "isom",
+			duration:      30037333 * time.Microsecond,
+			bitRate:       5132373,
+			videoIndex:    1,
+			videoBitRate:  4998172,
+			frameRate:     30,
+			displayWidth:  1920,
+			displayHeight: 1080,
+			audioIndex:    0,
+		},
+		{
+			// Recent ffmpeg reports a phone's rotation as a display
+			// matrix, counter-clockwise.
+			file:          "rotation_side_data.json",
+			formatName:    "mov,mp4,m4a,3gp,3g2,mj2",
+			majorBrand:    "qt  ",
+			duration:      9102268 * time.Microsecond,
+			bitRate:       7941866,
+			videoIndex:    0,
+			videoBitRate:  7843922,
+			frameRate:     29.67,
+			rotation:      90,
+			displayWidth:  1080,
+			displayHeight: 1920,
+			audioIndex:    1,
+		},
+		{
+			// Older ffmpeg reports it as a clockwise rotate tag.
+			file:          "rotation_tag.json",
+			formatName:    "mov,mp4,m4a,3gp,3g2,mj2",
+			majorBrand:    "isom",
+			duration:      6 * time.Second,
+			bitRate:       3002721,
+			videoIndex:    0,
+			videoBitRate:  3001254,
+			frameRate:     30,
+			rotation:      270,
+			displayWidth:  720,
+			displayHeight: 1280,
+			audioIndex:    -1,
+		},
+		{
+			// Matroska streams carry no bit_rate, and VP9 leaves the
+			// average frame rate at 0/0.
+			file:          "webm_no_bit_rate.json",
+			formatName:    "matroska,webm",
+			duration:      12521 * time.Millisecond,
+			bitRate:       743130,
+			videoIndex:    0,
+			videoBitRate:  0,
+			frameRate:     23.976,
+			displayWidth:  640,
+			displayHeight: 360,
+			audioIndex:    1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := Parse(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result.FormatName != tt.formatName {
+				t.Errorf("FormatName = %q, want %q", result.FormatName, tt.formatName)
+			}
+			if result.MajorBrand != tt.majorBrand {
+				t.Errorf("MajorBrand = %q, want %q", result.MajorBrand, tt.majorBrand)
+			}
+			if result.Duration != tt.duration {
+				t.Errorf("Duration = %s, want %s", result.Duration, tt.duration)
+			}
+			if result.BitRate != tt.bitRate {
+				t.Errorf("BitRate = %d, want %d", result.BitRate, tt.bitRate)
+			}
+
+			video, ok := result.FirstVideo()
+			if !ok {
+				t.Fatal("no video stream")
+			}
+			if video.Index != tt.videoIndex {
+				t.Errorf("video stream index = %d, want %d", video.Index, tt.videoIndex)
+			}
+			if video.BitRate != tt.videoBitRate {
+				t.Errorf("video BitRate = %d, want %d", video.BitRate, tt.videoBitRate)
+			}
+			if video.FrameRate != tt.frameRate {
+				t.Errorf("FrameRate = %v, want %v", video.FrameRate, tt.frameRate)
+			}
+			if video.Rotation != tt.rotation {
+				t.Errorf("Rotation = %d, want %d", video.Rotation, tt.rotation)
+			}
+			if w, h := video.DisplaySize(); w != tt.displayWidth || h != tt.displayHeight {
+				t.Errorf("DisplaySize = %dx%d, want %dx%d", w, h, tt.displayWidth, tt.displayHeight)
+			}
+
+			audio, ok := result.FirstAudio()
+			if tt.audioIndex < 0 {
+				if ok {
+					t.Errorf("found audio stream %d, want none", audio.Index)
+				}
+				return
+			}
+			if !ok || audio.Index != tt.audioIndex {
+				t.Errorf("audio stream = %d (found %v), want %d", audio.Index, ok, tt.audioIndex)
+			}
+		})
+	}
+}
+
+func TestParseRejectsInvalidJSON(t *testing.T) {
+	if _, err := Parse([]byte("ffprobe: not json")); err == nil {
+		t.Error("invalid output accepted")
+	}
+}
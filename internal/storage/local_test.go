@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalStorePath(t *testing.T) {
+	root := t.TempDir()
+	store, err := NewLocalStore("local", root, "http://localhost/assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := map[string]string{
+		"landscape/abc.mp4":       "landscape/abc.mp4",
+		"/landscape/abc.mp4":      "landscape/abc.mp4",
+		"raw/abc..def.mp4":        "raw/abc..def.mp4",
+		"thumbnails/id/..x/1.jpg": "thumbnails/id/..x/1.jpg",
+		"a/../b.mp4":              "b.mp4",
+	}
+	for key, want := range valid {
+		got, err := store.path(key)
+		if err != nil {
+			t.Errorf("path(%q): %v", key, err)
+			continue
+		}
+		if got != filepath.Join(root, filepath.FromSlash(want)) {
+			t.Errorf("path(%q) = %q, want %q under the root", key, got, want)
+		}
+	}
+
+	for _, key := range []string{"", "/", ".", "..", "../secret", "../../etc/passwd", "a/../../b"} {
+		if got, err := store.path(key); err == nil {
+			t.Errorf("path(%q) = %q, want an error", key, got)
+		}
+	}
+}
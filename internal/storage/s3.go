@@ -0,0 +1,390 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// The S3 API refuses parts smaller than 5 MiB (except the last) and more than
+// 10000 parts per upload.
+const (
+	minPartSize = 5 << 20
+	maxParts    = 10000
+)
+
+type S3Options struct {
+	// Objects larger than PartSize are sent with a multipart upload.
+	PartSize int64
+	// Concurrency is the number of parts uploaded in parallel.
+	Concurrency int
+	// PartRetries is how many extra attempts a failed part gets.
+	PartRetries int
+}
+
+type S3Store struct {
+	client *s3.Client
+	bucket string
+	opts   S3Options
+}
+
+func NewS3Store(client *s3.Client, bucket string, opts S3Options) *S3Store {
+	if opts.PartSize < minPartSize {
+		opts.PartSize = minPartSize
+	}
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	if opts.PartRetries < 0 {
+		opts.PartRetries = 0
+	}
+	return &S3Store{client: client, bucket: bucket, opts: opts}
+}
+
+func (s *S3Store) Bucket() string {
+	return s.bucket
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, body io.Reader, opts PutOptions) error {
+	if opts.Size > 0 && opts.Size <= s.opts.PartSize {
+		return s.putSingle(ctx, key, body, opts)
+	}
+
+	// The size is not always known up front, so read one part worth of data
+	// and only switch to multipart when there is more to come.
+	first := make([]byte, s.opts.PartSize)
+	n, err := io.ReadFull(body, first)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		opts.Size = int64(n)
+		return s.putSingle(ctx, key, bytes.NewReader(first[:n]), opts)
+	}
+	if err != nil {
+		return err
+	}
+	return s.putMultipart(ctx, key, first, body, opts)
+}
+
+func (s *S3Store) putSingle(ctx context.Context, key string, body io.Reader, opts PutOptions) error {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	if opts.Size > 0 {
+		input.ContentLength = aws.Int64(opts.Size)
+	}
+	_, err := s.client.PutObject(ctx, input)
+	return err
+}
+
+func (s *S3Store) putMultipart(ctx context.Context, key string, first []byte, body io.Reader, opts PutOptions) error {
+	createInput := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	if opts.ContentType != "" {
+		createInput.ContentType = aws.String(opts.ContentType)
+	}
+	created, err := s.client.CreateMultipartUpload(ctx, createInput)
+	if err != nil {
+		return err
+	}
+	uploadID := created.UploadId
+
+	partCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		parts    []types.CompletedPart
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	// The semaphore also bounds memory: only about Concurrency parts are held
+	// in buffers at any time.
+	sem := make(chan struct{}, s.opts.Concurrency)
+	dispatch := func(partNumber int32, data []byte) {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			part, err := s.uploadPart(partCtx, key, uploadID, partNumber, data)
+			if err != nil {
+				fail(fmt.Errorf("part %d: %w", partNumber, err))
+				return
+			}
+			mu.Lock()
+			parts = append(parts, part)
+			mu.Unlock()
+		}()
+	}
+
+	data := first
+	for partNumber := int32(1); partCtx.Err() == nil; partNumber++ {
+		if partNumber > maxParts {
+			fail(fmt.Errorf("object needs more than %d parts, increase the part size", maxParts))
+			break
+		}
+		dispatch(partNumber, data)
+
+		next := make([]byte, s.opts.PartSize)
+		n, err := io.ReadFull(body, next)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			fail(err)
+			break
+		}
+		data = next[:n]
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		s.abortMultipart(key, uploadID)
+		return firstErr
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return aws.ToInt32(parts[i].PartNumber) < aws.ToInt32(parts[j].PartNumber)
+	})
+	_, err = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		s.abortMultipart(key, uploadID)
+		return err
+	}
+	return nil
+}
+
+func (s *S3Store) uploadPart(ctx context.Context, key string, uploadID *string, partNumber int32, data []byte) (types.CompletedPart, error) {
+	sum := md5.Sum(data)
+	contentMD5 := base64.StdEncoding.EncodeToString(sum[:])
+
+	var lastErr error
+	for attempt := 0; attempt <= s.opts.PartRetries; attempt++ {
+		if attempt > 0 {
+			backoff := time.Duration(1<<(attempt-1)) * 500 * time.Millisecond
+			select {
+			case <-ctx.Done():
+				return types.CompletedPart{}, ctx.Err()
+			case <-time.After(backoff):
+			}
+		}
+
+		out, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        aws.String(s.bucket),
+			Key:           aws.String(key),
+			UploadId:      uploadID,
+			PartNumber:    aws.Int32(partNumber),
+			Body:          bytes.NewReader(data),
+			ContentLength: aws.Int64(int64(len(data))),
+			ContentMD5:    aws.String(contentMD5),
+		})
+		if err == nil {
+			return types.CompletedPart{
+				ETag:       out.ETag,
+				PartNumber: aws.Int32(partNumber),
+			}, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return types.CompletedPart{}, lastErr
+}
+
+// abortMultipart discards the parts of a failed upload so they don't linger
+// in the bucket and keep costing storage. It uses a fresh context because the
+// request context is usually what failed.
+func (s *S3Store) abortMultipart(key string, uploadID *string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+	})
+	if err != nil {
+		log.Printf("Couldn't abort multipart upload %s for %s: %v", aws.ToString(uploadID), key, err)
+	}
+}
+
+func (s *S3Store) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, ObjectInfo, error) {
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length >= 0 {
+		byteRange = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	}
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(byteRange),
+	})
+	if err != nil {
+		return nil, ObjectInfo{}, translateS3Error(err)
+	}
+
+	// ContentLength is only the range here, the total is after the slash of
+	// "bytes 0-99/1234".
+	size := aws.ToInt64(out.ContentLength)
+	if contentRange := aws.ToString(out.ContentRange); contentRange != "" {
+		if _, total, ok := strings.Cut(contentRange, "/"); ok {
+			if n, err := strconv.ParseInt(total, 10, 64); err == nil {
+				size = n
+			}
+		}
+	}
+	info := ObjectInfo{
+		Key:          key,
+		Size:         size,
+		ContentType:  aws.ToString(out.ContentType),
+		ETag:         aws.ToString(out.ETag),
+		LastModified: aws.ToTime(out.LastModified),
+	}
+	return out.Body, info, nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, ObjectInfo{}, translateS3Error(err)
+	}
+	info := ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(out.ContentLength),
+		ContentType:  aws.ToString(out.ContentType),
+		ETag:         aws.ToString(out.ETag),
+		LastModified: aws.ToTime(out.LastModified),
+	}
+	return out.Body, info, nil
+}
+
+func (s *S3Store) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return ObjectInfo{}, translateS3Error(err)
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(out.ContentLength),
+		ContentType:  aws.ToString(out.ContentType),
+		ETag:         aws.ToString(out.ETag),
+		LastModified: aws.ToTime(out.LastModified),
+	}, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return translateS3Error(err)
+}
+
+func (s *S3Store) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	objects := []ObjectInfo{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.ToString(obj.Key),
+				Size:         aws.ToInt64(obj.Size),
+				ETag:         aws.ToString(obj.ETag),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+func (s *S3Store) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	presignClient := s3.NewPresignClient(s.client, func(p *s3.PresignOptions) {
+		p.Expires = expires
+	})
+	req, err := presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+func (s *S3Store) PresignPut(ctx context.Context, key string, opts PutOptions, expires time.Duration) (string, error) {
+	presignClient := s3.NewPresignClient(s.client, func(p *s3.PresignOptions) {
+		p.Expires = expires
+	})
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	if opts.Size > 0 {
+		input.ContentLength = aws.Int64(opts.Size)
+	}
+	req, err := presignClient.PresignPutObject(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+func translateS3Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	if errors.As(err, &noSuchKey) || errors.As(err, &notFound) {
+		return ErrNotFound
+	}
+	return err
+}
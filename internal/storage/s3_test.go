@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeS3 implements just enough of the S3 API for Put: single PUTs and the
+// multipart calls, with path style addressing.
+type fakeS3 struct {
+	t *testing.T
+
+	mu sync.Mutex
+	// failures is how many more times each part number is refused.
+	failures  map[int]int
+	attempts  map[int]int
+	parts     map[int][]byte
+	completed []int
+	objects   map[string][]byte
+	aborted   []string
+}
+
+// newFakeS3 starts a fake S3 server and returns it with a constructor for
+// stores talking to it.
+func newFakeS3(t *testing.T) (*fakeS3, func(S3Options) *S3Store) {
+	t.Helper()
+	fake := &fakeS3{
+		t:        t,
+		failures: map[int]int{},
+		attempts: map[int]int{},
+		parts:    map[int][]byte{},
+		objects:  map[string][]byte{},
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+		// Retrying is S3Store's job here, not the SDK's.
+		Retryer: aws.NopRetryer{},
+	})
+	return fake, func(opts S3Options) *S3Store {
+		return NewS3Store(client, "test-bucket", opts)
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/test-bucket/")
+	query := r.URL.Query()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		f.t.Errorf("reading request: %v", err)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><Bucket>test-bucket</Bucket><Key>%s</Key><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>`, key)
+
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		f.attempts[partNumber]++
+		sum := md5.Sum(body)
+		if r.Header.Get("Content-MD5") != base64.StdEncoding.EncodeToString(sum[:]) {
+			f.t.Errorf("part %d: Content-MD5 %q doesn't match its body", partNumber, r.Header.Get("Content-MD5"))
+			writeS3Error(w, http.StatusBadRequest, "BadDigest")
+			return
+		}
+		if f.failures[partNumber] > 0 {
+			f.failures[partNumber]--
+			writeS3Error(w, http.StatusInternalServerError, "InternalError")
+			return
+		}
+		f.parts[partNumber] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sum))
+
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		var complete struct {
+			Parts []struct {
+				PartNumber int
+				ETag       string
+			} `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			f.t.Errorf("parsing CompleteMultipartUpload: %v", err)
+		}
+		var object []byte
+		for _, part := range complete.Parts {
+			sum := md5.Sum(f.parts[part.PartNumber])
+			if part.ETag != fmt.Sprintf(`"%x"`, sum) {
+				f.t.Errorf("part %d completed with ETag %s", part.PartNumber, part.ETag)
+			}
+			f.completed = append(f.completed, part.PartNumber)
+			object = append(object, f.parts[part.PartNumber]...)
+		}
+		f.objects[key] = object
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Bucket>test-bucket</Bucket><Key>%s</Key></CompleteMultipartUploadResult>`, key)
+
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		f.aborted = append(f.aborted, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+
+	default:
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
+
+func randomBytes(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+func TestS3PutSmallObjectInOnePiece(t *testing.T) {
+	fake, newStore := newFakeS3(t)
+	store := newStore(S3Options{})
+	data := randomBytes(1 << 20)
+
+	err := store.Put(context.Background(), "small.mp4", bytes.NewReader(data), PutOptions{ContentType: "video/mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.attempts) != 0 {
+		t.Errorf("small object sent as %d parts", len(fake.attempts))
+	}
+	if !bytes.Equal(fake.objects["small.mp4"], data) {
+		t.Error("stored object differs from what was put")
+	}
+}
+
+func TestS3PutMultipart(t *testing.T) {
+	fake, newStore := newFakeS3(t)
+	store := newStore(S3Options{PartSize: minPartSize, Concurrency: 2, PartRetries: 1})
+	// Part 2 fails once and succeeds on its retry.
+	fake.failures[2] = 1
+	data := randomBytes(2*minPartSize + 1234)
+
+	// No size given, as for a stream of unknown length.
+	err := store.Put(context.Background(), "large.mp4", bytes.NewReader(data), PutOptions{ContentType: "video/mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := []int{}
+	numbers := []int{}
+	for number := range fake.parts {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	for _, number := range numbers {
+		sizes = append(sizes, len(fake.parts[number]))
+	}
+	if fmt.Sprint(sizes) != fmt.Sprint([]int{minPartSize, minPartSize, 1234}) {
+		t.Errorf("part sizes %v", sizes)
+	}
+	if fake.attempts[1] != 1 || fake.attempts[2] != 2 || fake.attempts[3] != 1 {
+		t.Errorf("attempts per part %v, want part 2 retried once", fake.attempts)
+	}
+	if fmt.Sprint(fake.completed) != "[1 2 3]" {
+		t.Errorf("completed parts %v, want [1 2 3]", fake.completed)
+	}
+	if !bytes.Equal(fake.objects["large.mp4"], data) {
+		t.Error("assembled object differs from what was put")
+	}
+	if len(fake.aborted) != 0 {
+		t.Errorf("successful upload aborted: %v", fake.aborted)
+	}
+}
+
+func TestS3PutMultipartAbortsOnFailedPart(t *testing.T) {
+	fake, newStore := newFakeS3(t)
+	store := newStore(S3Options{PartSize: minPartSize, Concurrency: 1, PartRetries: 1})
+	// More failures than retries.
+	fake.failures[2] = 2
+	data := randomBytes(3 * minPartSize)
+
+	err := store.Put(context.Background(), "large.mp4", bytes.NewReader(data), PutOptions{Size: int64(len(data))})
+	if err == nil {
+		t.Fatal("Put succeeded with a part that never uploaded")
+	}
+	if fake.attempts[2] != 2 {
+		t.Errorf("part 2 attempted %d times, want 2", fake.attempts[2])
+	}
+	if fmt.Sprint(fake.aborted) != "[upload-1]" {
+		t.Errorf("aborted uploads %v, want [upload-1]", fake.aborted)
+	}
+	if len(fake.completed) != 0 {
+		t.Errorf("failed upload completed with parts %v", fake.completed)
+	}
+	if _, ok := fake.objects["large.mp4"]; ok {
+		t.Error("failed upload left an object behind")
+	}
+}
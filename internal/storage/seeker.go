@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// readSeekerChunk is the most a ReadSeeker asks the store for at once. A
+// player jumping around a long video then costs a few small requests
+// instead of open-ended ones that get cut off after a couple of bytes.
+var readSeekerChunk int64 = 4 << 20
+
+// ReadSeeker reads an object as an io.ReadSeeker, so it can go straight into
+// http.ServeContent. Seeking is free; reads fetch the object lazily in
+// chunks of at most readSeekerChunk from the current offset.
+type ReadSeeker struct {
+	ctx    context.Context
+	store  BlobStore
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+	// end is where the range body reads up to.
+	end int64
+}
+
+// NewReadSeeker reads key, whose size is already known from info.
+func NewReadSeeker(ctx context.Context, store BlobStore, info ObjectInfo) *ReadSeeker {
+	return &ReadSeeker{ctx: ctx, store: store, key: info.Key, size: info.Size}
+}
+
+func (r *ReadSeeker) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		length := min(readSeekerChunk, r.size-r.offset)
+		body, _, err := r.store.GetRange(r.ctx, r.key, r.offset, length)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+		r.end = r.offset + length
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF || r.offset >= r.end {
+		// The chunk is done; the next Read fetches the one after it.
+		r.Close()
+		if r.offset < r.size {
+			if n == 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			err = nil
+		}
+	}
+	return n, err
+}
+
+func (r *ReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.offset + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("negative position")
+	}
+	if abs != r.offset {
+		r.Close()
+		r.offset = abs
+	}
+	return abs, nil
+}
+
+func (r *ReadSeeker) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
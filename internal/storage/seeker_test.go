@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+)
+
+// rangeRecorder remembers the ranges asked of the store underneath.
+type rangeRecorder struct {
+	BlobStore
+	ranges [][2]int64
+}
+
+func (s *rangeRecorder) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, ObjectInfo, error) {
+	s.ranges = append(s.ranges, [2]int64{offset, length})
+	return s.BlobStore.GetRange(ctx, key, offset, length)
+}
+
+func TestReadSeekerBoundsRanges(t *testing.T) {
+	defer func(chunk int64) { readSeekerChunk = chunk }(readSeekerChunk)
+	readSeekerChunk = 10
+
+	ctx := context.Background()
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	memory := NewMemoryStore("test", "http://localhost/media")
+	if err := memory.Put(ctx, "video.mp4", bytes.NewReader(data), PutOptions{Size: int64(len(data))}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := memory.Head(ctx, "video.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &rangeRecorder{BlobStore: memory}
+	r := NewReadSeeker(ctx, store, info)
+	defer r.Close()
+
+	// What http.ServeContent does for "Range: bytes=5-17".
+	if _, err := r.Seek(5, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(io.LimitReader(r, 13))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(data[5:18]) {
+		t.Errorf("read %q, want %q", got, data[5:18])
+	}
+
+	// Seeking near the end asks for no more than is left.
+	if _, err := r.Seek(-4, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	got, err = io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "wxyz" {
+		t.Errorf("read %q, want %q", got, "wxyz")
+	}
+
+	want := [][2]int64{{5, 10}, {15, 10}, {32, 4}}
+	if len(store.ranges) != len(want) {
+		t.Fatalf("ranges %v, want %v", store.ranges, want)
+	}
+	for i := range want {
+		if store.ranges[i] != want[i] {
+			t.Errorf("ranges %v, want %v", store.ranges, want)
+			break
+		}
+	}
+}
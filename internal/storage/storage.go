@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	ErrNotFound     = errors.New("object not found")
+	ErrNotSupported = errors.New("operation not supported by this storage backend")
+)
+
+// ObjectInfo describes a stored object without its contents.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+}
+
+type PutOptions struct {
+	ContentType string
+	Size        int64
+}
+
+// BlobStore is the single entry point for reading and writing media,
+// regardless of whether it ends up in S3, on local disk or in memory.
+type BlobStore interface {
+	Bucket() string
+	Put(ctx context.Context, key string, body io.Reader, opts PutOptions) error
+	Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	// GetRange reads length bytes from offset, or through the end when
+	// length is negative. The returned info describes the whole object.
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, ObjectInfo, error)
+	Head(ctx context.Context, key string) (ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	PresignGet(ctx context.Context, key string, expires time.Duration) (string, error)
+	// PresignPut returns a URL clients can upload to directly. ContentType and
+	// Size in opts are part of the signature, so the client must send exactly
+	// those values.
+	PresignPut(ctx context.Context, key string, opts PutOptions, expires time.Duration) (string, error)
+}
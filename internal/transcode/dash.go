@@ -0,0 +1,41 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+)
+
+const DASHManifest = "manifest.mpd"
+
+// DASH transcodes input into the given ladder as fragmented MP4 with an MPD
+// manifest in outDir. Unlike HLS the audio is a single separate adaptation
+// set shared by every video representation.
+func DASH(ctx context.Context, input, outDir string, ladder []Rendition, hasAudio bool) error {
+	if len(ladder) == 0 {
+		return fmt.Errorf("empty rendition ladder")
+	}
+
+	args := []string{"-i", input}
+	args = append(args, videoLadderArgs(ladder)...)
+	adaptationSets := "id=0,streams=v"
+	if hasAudio {
+		args = append(args,
+			"-map", "0:a:0",
+			"-c:a", "aac",
+			"-b:a", ladder[0].AudioBitrate,
+		)
+		adaptationSets += " id=1,streams=a"
+	}
+	args = append(args,
+		"-f", "dash",
+		"-seg_duration", "6",
+		"-use_template", "1",
+		"-use_timeline", "1",
+		"-init_seg_name", "init-$RepresentationID$.m4s",
+		"-media_seg_name", "chunk-$RepresentationID$-$Number%05d$.m4s",
+		"-adaptation_sets", adaptationSets,
+		filepath.Join(outDir, DASHManifest),
+	)
+	return runFFmpeg(ctx, args...)
+}
@@ -0,0 +1,29 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// FFmpegPath is the ffmpeg binary used for every command in this package.
+var FFmpegPath = "ffmpeg"
+
+// runFFmpeg runs ffmpeg and folds the tail of its stderr into the error, since
+// the exit status alone never says what went wrong.
+func runFFmpeg(ctx context.Context, args ...string) error {
+	args = append([]string{"-hide_banner", "-loglevel", "error", "-y"}, args...)
+	cmd := exec.CommandContext(ctx, FFmpegPath, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > 500 {
+			msg = msg[len(msg)-500:]
+		}
+		return fmt.Errorf("ffmpeg: %w: %s", err, msg)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ExtractFrame writes the frame at the given position of input to output as
+// a JPEG.
+func ExtractFrame(ctx context.Context, input, output string, at time.Duration) error {
+	return runFFmpeg(ctx,
+		"-ss", strconv.FormatFloat(at.Seconds(), 'f', 3, 64),
+		"-i", input,
+		"-frames:v", "1",
+		"-q:v", "2",
+		output,
+	)
+}
+
+// ExtractSceneFrames writes up to max frames where the picture changes
+// noticeably from the previous one to outDir as scene_01.jpg, scene_02.jpg,
+// ... Threshold is ffmpeg's scene score, 0..1; around 0.4 catches real cuts.
+func ExtractSceneFrames(ctx context.Context, input, outDir string, threshold float64, max int) error {
+	return runFFmpeg(ctx,
+		"-i", input,
+		"-vf", fmt.Sprintf("select='gt(scene,%.2f)'", threshold),
+		"-fps_mode", "vfr",
+		"-frames:v", strconv.Itoa(max),
+		"-q:v", "2",
+		filepath.Join(outDir, "scene_%02d.jpg"),
+	)
+}
+
+// DecodeFirstFrame decodes the first frame of input's first video stream and
+// discards it, failing if ffmpeg can't.
+func DecodeFirstFrame(ctx context.Context, input string) error {
+	return runFFmpeg(ctx,
+		"-i", input,
+		"-map", "0:v:0",
+		"-frames:v", "1",
+		"-f", "null",
+		"-",
+	)
+}
@@ -0,0 +1,133 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Rendition is one rung of the adaptive bitrate ladder.
+type Rendition struct {
+	Name string
+	// Height is the short side of the frame: the height of landscape video
+	// and the width of portrait video, so a 1080p rung is 1080 pixels across
+	// either way.
+	Height       int
+	VideoBitrate string
+	AudioBitrate string
+	// Portrait is set by LadderFor for sources taller than they are wide.
+	Portrait bool
+}
+
+var DefaultLadder = []Rendition{
+	{Name: "1080p", Height: 1080, VideoBitrate: "5000k", AudioBitrate: "192k"},
+	{Name: "720p", Height: 720, VideoBitrate: "2800k", AudioBitrate: "128k"},
+	{Name: "480p", Height: 480, VideoBitrate: "1400k", AudioBitrate: "128k"},
+	{Name: "240p", Height: 240, VideoBitrate: "400k", AudioBitrate: "64k"},
+}
+
+const HLSMasterPlaylist = "master.m3u8"
+
+// LadderFor drops the renditions larger than the source so nothing gets
+// upscaled, comparing against the short side of the displayed frame. A
+// source smaller than every rung still gets the lowest one.
+func LadderFor(ladder []Rendition, displayWidth, displayHeight int) []Rendition {
+	portrait := displayHeight > displayWidth
+	shortSide := min(displayWidth, displayHeight)
+
+	capped := []Rendition{}
+	for _, r := range ladder {
+		if r.Height <= shortSide {
+			r.Portrait = portrait
+			capped = append(capped, r)
+		}
+	}
+	if len(capped) == 0 && len(ladder) > 0 {
+		r := ladder[len(ladder)-1]
+		r.Portrait = portrait
+		capped = append(capped, r)
+	}
+	return capped
+}
+
+// videoLadderArgs builds the scaling filter graph and per-rendition video
+// encoder options shared by the HLS and DASH outputs.
+func videoLadderArgs(ladder []Rendition) []string {
+	splits := make([]string, len(ladder))
+	scales := make([]string, len(ladder))
+	for i, r := range ladder {
+		splits[i] = fmt.Sprintf("[v%d]", i)
+		// -2 keeps the long side even, which libx264 requires. ffmpeg has
+		// already applied any rotation, so this is the displayed shape.
+		scale := fmt.Sprintf("-2:%d", r.Height)
+		if r.Portrait {
+			scale = fmt.Sprintf("%d:-2", r.Height)
+		}
+		scales[i] = fmt.Sprintf("[v%d]scale=%s[v%dout]", i, scale, i)
+	}
+	filter := fmt.Sprintf("[0:v]split=%d%s;%s", len(ladder), strings.Join(splits, ""), strings.Join(scales, ";"))
+
+	args := []string{"-filter_complex", filter}
+	for i, r := range ladder {
+		args = append(args,
+			"-map", fmt.Sprintf("[v%dout]", i),
+			fmt.Sprintf("-c:v:%d", i), "libx264",
+			fmt.Sprintf("-b:v:%d", i), r.VideoBitrate,
+			fmt.Sprintf("-maxrate:v:%d", i), r.VideoBitrate,
+			fmt.Sprintf("-bufsize:v:%d", i), r.VideoBitrate,
+		)
+	}
+	// Aligned keyframes every two seconds let players switch renditions at
+	// segment boundaries.
+	args = append(args,
+		"-preset", "veryfast",
+		"-g", "48", "-keyint_min", "48", "-sc_threshold", "0",
+		"-force_key_frames", "expr:gte(t,n_forced*2)",
+	)
+	return args
+}
+
+// HLS transcodes input into the given ladder as an HLS package in outDir: a
+// master playlist plus one directory of segments per rendition.
+func HLS(ctx context.Context, input, outDir string, ladder []Rendition, hasAudio bool) error {
+	if len(ladder) == 0 {
+		return fmt.Errorf("empty rendition ladder")
+	}
+
+	streamMap := make([]string, len(ladder))
+	for i, r := range ladder {
+		if err := os.MkdirAll(filepath.Join(outDir, r.Name), 0755); err != nil {
+			return err
+		}
+		if hasAudio {
+			streamMap[i] = fmt.Sprintf("v:%d,a:%d,name:%s", i, i, r.Name)
+		} else {
+			streamMap[i] = fmt.Sprintf("v:%d,name:%s", i, r.Name)
+		}
+	}
+
+	args := []string{"-i", input}
+	args = append(args, videoLadderArgs(ladder)...)
+	// HLS variants are muxed, so every rendition carries its own audio copy.
+	if hasAudio {
+		for i, r := range ladder {
+			args = append(args,
+				"-map", "0:a:0",
+				fmt.Sprintf("-c:a:%d", i), "aac",
+				fmt.Sprintf("-b:a:%d", i), r.AudioBitrate,
+			)
+		}
+	}
+	args = append(args,
+		"-f", "hls",
+		"-hls_time", "6",
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(outDir, "%v", "segment_%04d.ts"),
+		"-master_pl_name", HLSMasterPlaylist,
+		"-var_stream_map", strings.Join(streamMap, " "),
+		filepath.Join(outDir, "%v", "index.m3u8"),
+	)
+	return runFFmpeg(ctx, args...)
+}
@@ -0,0 +1,30 @@
+package transcode
+
+import "context"
+
+// NormalizeMP4 rewrites input as an H.264/AAC MP4 at output. Streams that
+// already use those codecs are copied instead of re-encoded.
+func NormalizeMP4(ctx context.Context, input, output string, copyVideo, copyAudio bool) error {
+	args := []string{
+		"-i", input,
+		"-map", "0:v:0",
+		"-map", "0:a:0?",
+	}
+	if copyVideo {
+		args = append(args, "-c:v", "copy")
+	} else {
+		args = append(args,
+			"-c:v", "libx264",
+			"-preset", "medium",
+			"-crf", "20",
+			"-pix_fmt", "yuv420p",
+		)
+	}
+	if copyAudio {
+		args = append(args, "-c:a", "copy")
+	} else {
+		args = append(args, "-c:a", "aac", "-b:a", "160k")
+	}
+	args = append(args, "-f", "mp4", output)
+	return runFFmpeg(ctx, args...)
+}
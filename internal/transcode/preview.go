@@ -0,0 +1,50 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// PreviewFormat is the container of an animated preview clip.
+type PreviewFormat string
+
+const (
+	PreviewWebP PreviewFormat = "webp"
+	PreviewMP4  PreviewFormat = "mp4"
+)
+
+// AnimatedPreview writes a silent, looping clip of length starting at start
+// from input to output, scaled down to width. WebP previews loop on their
+// own; MP4 ones rely on the player's loop attribute.
+func AnimatedPreview(ctx context.Context, input, output string, start, length time.Duration, width int, format PreviewFormat) error {
+	args := []string{
+		"-ss", strconv.FormatFloat(start.Seconds(), 'f', 3, 64),
+		"-t", strconv.FormatFloat(length.Seconds(), 'f', 3, 64),
+		"-i", input,
+		"-an",
+	}
+	switch format {
+	case PreviewWebP:
+		args = append(args,
+			"-vf", fmt.Sprintf("fps=12,scale=%d:-2", width),
+			"-c:v", "libwebp",
+			"-quality", "60",
+			"-loop", "0",
+		)
+	case PreviewMP4:
+		args = append(args,
+			"-vf", fmt.Sprintf("scale=%d:-2", width),
+			"-c:v", "libx264",
+			"-preset", "veryfast",
+			"-crf", "28",
+			"-pix_fmt", "yuv420p",
+			"-movflags", "+faststart",
+		)
+	default:
+		return fmt.Errorf("unknown preview format %q", format)
+	}
+	args = append(args, output)
+	return runFFmpeg(ctx, args...)
+}
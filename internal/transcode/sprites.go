@@ -0,0 +1,83 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SpriteVTT is the name of the WebVTT track Sprites writes next to the
+// sheets.
+const SpriteVTT = "thumbnails.vtt"
+
+// SpriteOptions describes the sprite sheets for a seek preview: one tile
+// every Interval, laid out Columns by Rows per sheet.
+type SpriteOptions struct {
+	Interval   time.Duration
+	TileWidth  int
+	TileHeight int
+	Columns    int
+	Rows       int
+}
+
+// Sprites writes the tiled sprite sheets for input to outDir as
+// sprite_001.jpg, sprite_002.jpg, ... along with a WebVTT track mapping each
+// interval to its tile. duration is the length of input.
+func Sprites(ctx context.Context, input, outDir string, duration time.Duration, opts SpriteOptions) error {
+	if opts.Interval <= 0 || opts.Columns <= 0 || opts.Rows <= 0 {
+		return fmt.Errorf("invalid sprite options")
+	}
+	if duration <= 0 {
+		return fmt.Errorf("unknown duration")
+	}
+
+	filter := fmt.Sprintf("fps=1/%s,scale=%d:%d,tile=%dx%d",
+		formatSeconds(opts.Interval), opts.TileWidth, opts.TileHeight, opts.Columns, opts.Rows)
+	err := runFFmpeg(ctx,
+		"-i", input,
+		"-an",
+		"-vf", filter,
+		"-q:v", "4",
+		filepath.Join(outDir, "sprite_%03d.jpg"),
+	)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(outDir, SpriteVTT), []byte(spriteTrack(duration, opts)), 0o644)
+}
+
+// spriteTrack builds the WebVTT cues. Sheets are referenced relative to the
+// track, with a media fragment picking the tile.
+func spriteTrack(duration time.Duration, opts SpriteOptions) string {
+	perSheet := opts.Columns * opts.Rows
+	tiles := int(math.Ceil(float64(duration) / float64(opts.Interval)))
+
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for i := 0; i < tiles; i++ {
+		start := time.Duration(i) * opts.Interval
+		end := min(start+opts.Interval, duration)
+		sheet := i/perSheet + 1
+		col := i % perSheet % opts.Columns
+		row := i % perSheet / opts.Columns
+
+		fmt.Fprintf(&b, "\n%s --> %s\n", vttTimestamp(start), vttTimestamp(end))
+		fmt.Fprintf(&b, "sprite_%03d.jpg#xywh=%d,%d,%d,%d\n",
+			sheet, col*opts.TileWidth, row*opts.TileHeight, opts.TileWidth, opts.TileHeight)
+	}
+	return b.String()
+}
+
+func vttTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+func formatSeconds(d time.Duration) string {
+	return strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.3f", d.Seconds()), "0"), ".")
+}
@@ -0,0 +1,18 @@
+package transcode
+
+import (
+	"context"
+	"strconv"
+)
+
+// EncodeWebP converts the still image at input to a WebP at output. quality
+// is libwebp's 0..100 scale.
+func EncodeWebP(ctx context.Context, input, output string, quality int) error {
+	return runFFmpeg(ctx,
+		"-i", input,
+		"-c:v", "libwebp",
+		"-quality", strconv.Itoa(quality),
+		"-frames:v", "1",
+		output,
+	)
+}
@@ -0,0 +1,259 @@
+// Package validation checks uploaded media against the server's limits
+// before it is accepted for processing.
+package validation
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mediaprobe"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+)
+
+// Rule names the check an upload failed, so clients can tell what to fix.
+type Rule string
+
+const (
+	RuleContentType Rule = "content_type"
+	RuleFileSize    Rule = "max_file_size"
+	RuleVideoStream Rule = "video_stream"
+	RuleDuration    Rule = "max_duration"
+	RuleResolution  Rule = "max_resolution"
+)
+
+// Error is a rule the upload broke. Anything else Validate returns is a
+// failure on our side.
+type Error struct {
+	Rule    Rule   `json:"rule"`
+	Message string `json:"message"`
+	Limit   string `json:"limit,omitempty"`
+	Actual  string `json:"actual,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Rule, e.Message)
+}
+
+// StatusCode is the HTTP status an upload failing this rule is rejected with.
+func (e *Error) StatusCode() int {
+	switch e.Rule {
+	case RuleContentType:
+		return http.StatusUnsupportedMediaType
+	case RuleFileSize:
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusUnprocessableEntity
+	}
+}
+
+// Limits are the bounds an upload must stay within. Zero disables a limit.
+// MaxWidth and MaxHeight apply regardless of orientation, so a portrait
+// video may be MaxHeight wide and MaxWidth tall.
+type Limits struct {
+	MaxFileSize int64
+	MaxDuration time.Duration
+	MaxWidth    int
+	MaxHeight   int
+}
+
+// CheckSize rejects uploads over the file size limit.
+func CheckSize(size int64, limits Limits) error {
+	if limits.MaxFileSize > 0 && size > limits.MaxFileSize {
+		return &Error{
+			Rule:    RuleFileSize,
+			Message: "file is too large",
+			Limit:   fmt.Sprintf("%d bytes", limits.MaxFileSize),
+			Actual:  fmt.Sprintf("%d bytes", size),
+		}
+	}
+	return nil
+}
+
+// SizeExceeded rejects an upload that was cut off at the file size limit
+// before its full size was known.
+func SizeExceeded(limits Limits) error {
+	return &Error{
+		Rule:    RuleFileSize,
+		Message: "file is too large",
+		Limit:   fmt.Sprintf("%d bytes", limits.MaxFileSize),
+	}
+}
+
+// sniffLen is how much of a file the magic byte checks look at.
+const sniffLen = 512
+
+// containerFamilies groups types that share a file format. Clients mix them
+// up constantly, a .mov labelled video/mp4 is still an ISO media file.
+var containerFamilies = map[string]string{
+	"video/mp4":        "isobmff",
+	"video/quicktime":  "isobmff",
+	"video/webm":       "ebml",
+	"video/x-matroska": "ebml",
+}
+
+// SniffVideo identifies a video container from its first bytes, returning ""
+// for anything it doesn't recognise.
+func SniffVideo(header []byte) string {
+	switch {
+	case len(header) >= 12 && bytes.Equal(header[4:8], []byte("ftyp")):
+		if bytes.Equal(header[8:12], []byte("qt  ")) {
+			return "video/quicktime"
+		}
+		return "video/mp4"
+	case len(header) >= 8 && (bytes.Equal(header[4:8], []byte("moov")) ||
+		bytes.Equal(header[4:8], []byte("mdat")) ||
+		bytes.Equal(header[4:8], []byte("wide"))):
+		// Old QuickTime files start straight with an atom other than ftyp.
+		return "video/quicktime"
+	case bytes.HasPrefix(header, []byte{0x1a, 0x45, 0xdf, 0xa3}):
+		if bytes.Contains(header, []byte("webm")) {
+			return "video/webm"
+		}
+		return "video/x-matroska"
+	}
+	return ""
+}
+
+// SniffImage identifies an image from its first bytes.
+func SniffImage(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0xff, 0xd8, 0xff}):
+		return "image/jpeg"
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case len(header) >= 12 && bytes.Equal(header[:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WEBP")):
+		return "image/webp"
+	case bytes.HasPrefix(header, []byte("GIF87a")) || bytes.HasPrefix(header, []byte("GIF89a")):
+		return "image/gif"
+	}
+	return ""
+}
+
+// ValidateVideo checks the file at path: its magic bytes must match the
+// claimed type, it must fit the limits, and ffmpeg must be able to decode a
+// frame from its first video stream. The probe is returned for checks the
+// caller makes on what the file really is.
+func ValidateVideo(ctx context.Context, path, claimedType string, limits Limits) (mediaprobe.Result, error) {
+	var probe mediaprobe.Result
+	f, err := os.Open(path)
+	if err != nil {
+		return probe, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return probe, err
+	}
+	header := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, header)
+	f.Close()
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return probe, err
+	}
+
+	sniffed := SniffVideo(header[:n])
+	if sniffed == "" || containerFamilies[sniffed] != containerFamilies[claimedType] {
+		return probe, &Error{
+			Rule:    RuleContentType,
+			Message: "file contents don't match the declared content type",
+			Limit:   claimedType,
+			Actual:  sniffed,
+		}
+	}
+
+	if err := CheckSize(stat.Size(), limits); err != nil {
+		return probe, err
+	}
+
+	probe, err = mediaprobe.Probe(ctx, path)
+	if err != nil {
+		return probe, &Error{
+			Rule:    RuleVideoStream,
+			Message: "file couldn't be read as media",
+		}
+	}
+	videoStream, ok := probe.FirstVideo()
+	if !ok || videoStream.Width <= 0 || videoStream.Height <= 0 {
+		return probe, &Error{
+			Rule:    RuleVideoStream,
+			Message: "file has no video stream",
+		}
+	}
+	if err := transcode.DecodeFirstFrame(ctx, path); err != nil {
+		return probe, &Error{
+			Rule:    RuleVideoStream,
+			Message: "video stream couldn't be decoded",
+			Actual:  videoStream.CodecName,
+		}
+	}
+
+	if limits.MaxDuration > 0 && probe.Duration > limits.MaxDuration {
+		return probe, &Error{
+			Rule:    RuleDuration,
+			Message: "video is too long",
+			Limit:   limits.MaxDuration.String(),
+			Actual:  probe.Duration.Round(time.Second).String(),
+		}
+	}
+
+	if limits.MaxWidth > 0 && limits.MaxHeight > 0 {
+		long, short := max(videoStream.Width, videoStream.Height), min(videoStream.Width, videoStream.Height)
+		maxLong, maxShort := max(limits.MaxWidth, limits.MaxHeight), min(limits.MaxWidth, limits.MaxHeight)
+		if long > maxLong || short > maxShort {
+			return probe, &Error{
+				Rule:    RuleResolution,
+				Message: "video resolution is too high",
+				Limit:   fmt.Sprintf("%dx%d", limits.MaxWidth, limits.MaxHeight),
+				Actual:  fmt.Sprintf("%dx%d", videoStream.Width, videoStream.Height),
+			}
+		}
+	}
+	return probe, nil
+}
+
+// ValidateImage checks an uploaded image against the allowed types and the
+// size limit and makes sure it decodes, returning its sniffed type.
+func ValidateImage(data []byte, allowed []string, limits Limits) (string, error) {
+	mediaType := SniffImage(data[:min(len(data), sniffLen)])
+	if !slices.Contains(allowed, mediaType) {
+		return "", &Error{
+			Rule:    RuleContentType,
+			Message: "image type is not supported",
+			Limit:   strings.Join(allowed, ", "),
+			Actual:  mediaType,
+		}
+	}
+
+	if err := CheckSize(int64(len(data)), limits); err != nil {
+		return "", err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", &Error{
+			Rule:    RuleContentType,
+			Message: "image couldn't be decoded",
+			Actual:  mediaType,
+		}
+	}
+	if limits.MaxWidth > 0 && limits.MaxHeight > 0 &&
+		(config.Width > limits.MaxWidth || config.Height > limits.MaxHeight) {
+		return "", &Error{
+			Rule:    RuleResolution,
+			Message: "image resolution is too high",
+			Limit:   fmt.Sprintf("%dx%d", limits.MaxWidth, limits.MaxHeight),
+			Actual:  fmt.Sprintf("%dx%d", config.Width, config.Height),
+		}
+	}
+	return mediaType, nil
+}
@@ -2,37 +2,60 @@ package main
 
 import (
 	"context"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cdn"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
-	"github.com/google/uuid"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/validation"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 type apiConfig struct {
-	db               database.Client
-	jwtSecret        string
-	platform         string
-	filepathRoot     string
-	assetsRoot       string
-	s3Bucket         string
-	s3Region         string
-	s3CfDistribution string
-	port             string
-	s3Client         *s3.Client
+	db                database.Client
+	jwtSecret         string
+	platform          string
+	filepathRoot      string
+	assetsRoot        string
+	s3Bucket          string
+	s3Region          string
+	s3CfDistribution  string
+	port              string
+	s3Client          *s3.Client
+	storageBackend    string
+	videoStore        storage.BlobStore
+	uploadsRoot       string
+	s3Endpoint        string
+	s3Options         storage.S3Options
+	jobs              *jobs.Queue
+	hlsEnabled        bool
+	dashEnabled       bool
+	autoThumbnails    bool
+	spriteInterval    time.Duration
+	previewFormat     transcode.PreviewFormat
+	allowedVideoTypes map[string]bool
+	uploadLimits      validation.Limits
+	publicBaseURL     string
+	thumbnailBaseURL  string
+	deliveryMode      string
+	cdnSigner         *cdn.Signer
+	cdnCookieDomain   string
+	urlLifetimes      urlLifetimes
+	signedURLs        *signedURLCache
+	thumbnailLimits   validation.Limits
+	// deletionWake nudges the storage deletion worker when a video
+	// is deleted, instead of waiting for its next poll.
+	deletionWake chan struct{}
 }
 
-type thumbnail struct {
-	data      []byte
-	mediaType string
-}
-
-var videoThumbnails = map[uuid.UUID]thumbnail{}
-
 func main() {
 	godotenv.Load(".env")
 
@@ -66,40 +89,143 @@ func main() {
 		log.Fatal("ASSETS_ROOT environment variable is not set")
 	}
 
+	port := os.Getenv("PORT")
+	if port == "" {
+		log.Fatal("PORT environment variable is not set")
+	}
+
+	uploadsRoot := os.Getenv("UPLOADS_ROOT")
+	if uploadsRoot == "" {
+		uploadsRoot = filepath.Join(os.TempDir(), "tubely-uploads")
+	}
+
+	storageBackend := os.Getenv("STORAGE_BACKEND")
+	if storageBackend == "" {
+		storageBackend = "s3"
+	}
+
+	// S3 settings are only mandatory when videos actually go to S3, so the
+	// server can run fully offline with the local or memory backends.
 	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
+	if s3Bucket == "" && storageBackend == "s3" {
 		log.Fatal("S3_BUCKET environment variable is not set")
 	}
 
 	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
+	if s3Region == "" && storageBackend == "s3" {
 		log.Fatal("S3_REGION environment variable is not set")
 	}
 
 	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
+	if s3CfDistribution == "" && storageBackend == "s3" {
 		log.Fatal("S3_CF_DISTRO environment variable is not set")
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable is not set")
+	// S3_ENDPOINT points the client at an S3-compatible stand-in such as
+	// MinIO for local testing.
+	s3Endpoint := os.Getenv("S3_ENDPOINT")
+
+	s3Options := storage.S3Options{
+		PartSize:    int64(envInt("S3_PART_SIZE_MB", 16)) << 20,
+		Concurrency: envInt("S3_UPLOAD_CONCURRENCY", 4),
+		PartRetries: envInt("S3_PART_RETRIES", 3),
+	}
+
+	// PREVIEW_FORMAT picks the animated listing preview; "none" skips it.
+	previewFormat := transcode.PreviewFormat(os.Getenv("PREVIEW_FORMAT"))
+	switch previewFormat {
+	case "":
+		previewFormat = transcode.PreviewWebP
+	case "none":
+		previewFormat = ""
+	case transcode.PreviewWebP, transcode.PreviewMP4:
+	default:
+		log.Fatalf("PREVIEW_FORMAT must be webp, mp4 or none, got %q", previewFormat)
+	}
+
+	// PUBLIC_BASE_URL is where clients reach this server, for links to media
+	// it serves itself with the local and memory backends.
+	publicBaseURL := strings.TrimSuffix(os.Getenv("PUBLIC_BASE_URL"), "/")
+	if publicBaseURL == "" {
+		publicBaseURL = "http://localhost:" + port
+	}
+
+	// THUMBNAIL_BASE_URL serves thumbnails and previews from a CDN or public
+	// bucket as-is instead of signing a URL for every read.
+	thumbnailBaseURL := strings.TrimSuffix(os.Getenv("THUMBNAIL_BASE_URL"), "/")
+
+	deliveryMode := os.Getenv("DELIVERY_MODE")
+	if deliveryMode == "" {
+		deliveryMode = deliveryPresign
 	}
-	loadDefaultConfig, err := config.LoadDefaultConfig(context.Background())
+	var cdnSigner *cdn.Signer
+	switch deliveryMode {
+	case deliveryPresign:
+	case deliveryCloudFront:
+		if storageBackend != "s3" || s3CfDistribution == "" {
+			log.Fatal("DELIVERY_MODE=cloudfront needs the s3 backend and S3_CF_DISTRO")
+		}
+		// Playback cookies are useless unless the browser sends them to
+		// the distribution's domain.
+		if os.Getenv("CLOUDFRONT_COOKIE_DOMAIN") == "" {
+			log.Fatal("DELIVERY_MODE=cloudfront needs CLOUDFRONT_COOKIE_DOMAIN")
+		}
+		cdnSigner, err = loadCDNSigner()
+		if err != nil {
+			log.Fatalf("Couldn't load CloudFront key pair: %v", err)
+		}
+	default:
+		log.Fatalf("DELIVERY_MODE must be presign or cloudfront, got %q", deliveryMode)
+	}
+
+	allowedTypesSetting := os.Getenv("ALLOWED_VIDEO_TYPES")
+	if allowedTypesSetting == "" {
+		allowedTypesSetting = defaultAllowedVideoTypes
+	}
+	allowedVideoTypes, err := parseAllowedVideoTypes(allowedTypesSetting)
 	if err != nil {
-		return
+		log.Fatalf("Invalid ALLOWED_VIDEO_TYPES: %v", err)
 	}
+
 	cfg := apiConfig{
-		db:               db,
-		jwtSecret:        jwtSecret,
-		platform:         platform,
-		filepathRoot:     filepathRoot,
-		assetsRoot:       assetsRoot,
-		s3Bucket:         s3Bucket,
-		s3Region:         s3Region,
-		s3CfDistribution: s3CfDistribution,
-		port:             port,
-		s3Client:         s3.NewFromConfig(loadDefaultConfig),
+		db:                db,
+		jwtSecret:         jwtSecret,
+		platform:          platform,
+		filepathRoot:      filepathRoot,
+		assetsRoot:        assetsRoot,
+		s3Bucket:          s3Bucket,
+		s3Region:          s3Region,
+		s3CfDistribution:  s3CfDistribution,
+		port:              port,
+		storageBackend:    storageBackend,
+		uploadsRoot:       uploadsRoot,
+		s3Endpoint:        s3Endpoint,
+		s3Options:         s3Options,
+		hlsEnabled:        envBool("HLS_ENABLED", true),
+		dashEnabled:       envBool("DASH_ENABLED", false),
+		autoThumbnails:    envBool("AUTO_THUMBNAILS", true),
+		spriteInterval:    time.Duration(envInt("SPRITE_INTERVAL_SECONDS", 5)) * time.Second,
+		previewFormat:     previewFormat,
+		allowedVideoTypes: allowedVideoTypes,
+		publicBaseURL:     publicBaseURL,
+		thumbnailBaseURL:  thumbnailBaseURL,
+		deliveryMode:      deliveryMode,
+		cdnSigner:         cdnSigner,
+		cdnCookieDomain:   os.Getenv("CLOUDFRONT_COOKIE_DOMAIN"),
+		urlLifetimes:      lifetimesFromEnv(),
+		signedURLs:        newSignedURLCache(),
+		deletionWake:      make(chan struct{}, 1),
+		uploadLimits: validation.Limits{
+			MaxFileSize: int64(envInt("MAX_UPLOAD_MB", 10240)) << 20,
+			MaxDuration: time.Duration(envInt("MAX_VIDEO_DURATION_SECONDS", 4*60*60)) * time.Second,
+			MaxWidth:    envInt("MAX_VIDEO_WIDTH", 3840),
+			MaxHeight:   envInt("MAX_VIDEO_HEIGHT", 2160),
+		},
+		thumbnailLimits: validation.Limits{
+			MaxFileSize: int64(envInt("MAX_THUMBNAIL_MB", 10)) << 20,
+			MaxWidth:    8192,
+			MaxHeight:   8192,
+		},
 	}
 
 	err = cfg.ensureAssetsDir()
@@ -107,6 +233,44 @@ func main() {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
+	err = cfg.ensureUploadsDir()
+	if err != nil {
+		log.Fatalf("Couldn't create uploads directory: %v", err)
+	}
+
+	err = cfg.configureStorage()
+	if err != nil {
+		log.Fatalf("Couldn't configure storage: %v", err)
+	}
+	err = db.MigrateStorageRefs(cfg.storageBackend)
+	if err != nil {
+		log.Fatalf("Couldn't migrate stored video references: %v", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "gc" {
+		cfg.runGCCommand(os.Args[2:])
+		return
+	}
+
+	cfg.jobs = jobs.NewQueue(db, jobs.Options{
+		Workers:     envInt("JOB_WORKERS", 2),
+		MaxAttempts: envInt("JOB_MAX_ATTEMPTS", 3),
+	})
+	cfg.jobs.Register(jobTypeProcessVideo, cfg.handleProcessVideoJob)
+	err = cfg.jobs.Start(context.Background())
+	if err != nil {
+		log.Fatalf("Couldn't start job workers: %v", err)
+	}
+	storageDeletionInterval := time.Duration(envInt("STORAGE_DELETION_INTERVAL_SECONDS", 30)) * time.Second
+	go cfg.runStorageDeletions(context.Background(), storageDeletionInterval)
+	go cfg.runUploadExpiry(context.Background(), time.Duration(envInt("UPLOAD_EXPIRY_HOURS", 24))*time.Hour)
+	if gcInterval := envInt("GC_INTERVAL_HOURS", 0); gcInterval > 0 {
+		go cfg.runPeriodicGC(context.Background(), time.Duration(gcInterval)*time.Hour, gcOptions{
+			GracePeriod: gcGracePeriod(),
+			DryRun:      envBool("GC_DRY_RUN", false),
+		})
+	}
+
 	mux := http.NewServeMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
@@ -114,6 +278,10 @@ func main() {
 	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
 	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
 
+	if cfg.storageBackend == "memory" {
+		mux.HandleFunc("GET /media/{key...}", cfg.handlerMediaGet)
+	}
+
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
@@ -123,10 +291,21 @@ func main() {
 	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
 	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
 	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
+	mux.HandleFunc("POST /api/video_upload/{videoID}/resumable", cfg.handlerResumableUploadCreate)
+	mux.HandleFunc("POST /api/video_upload/{videoID}/direct", cfg.handlerDirectUploadCreate)
+	mux.HandleFunc("POST /api/video_upload/{videoID}/direct/complete", cfg.handlerDirectUploadComplete)
+	mux.HandleFunc("HEAD /api/uploads/{uploadID}", cfg.handlerResumableUploadHead)
+	mux.HandleFunc("PATCH /api/uploads/{uploadID}", cfg.handlerResumableUploadPatch)
+	mux.HandleFunc("POST /api/uploads/{uploadID}/complete", cfg.handlerResumableUploadComplete)
 	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
 	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
-	//	mux.HandleFunc("GET /api/thumbnails/{videoID}", cfg.handlerThumbnailGet)
 	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	mux.HandleFunc("GET /api/jobs/{jobID}", cfg.handlerJobGet)
+	mux.HandleFunc("POST /api/videos/{videoID}/playback_cookies", cfg.handlerPlaybackCookies)
+	mux.HandleFunc("GET /api/videos/{videoID}/stream", cfg.handlerVideoStream)
+	mux.HandleFunc("GET /api/videos/{videoID}/packages/{path...}", cfg.handlerVideoPackageGet)
+	mux.HandleFunc("GET /api/videos/{videoID}/thumbnail_candidates", cfg.handlerThumbnailCandidatesList)
+	mux.HandleFunc("POST /api/videos/{videoID}/thumbnail_candidates/{candidateID}/select", cfg.handlerThumbnailCandidateSelect)
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
 
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mediaprobe"
+	"github.com/google/uuid"
+)
+
+func mediaInfoFromProbe(videoID uuid.UUID, probe mediaprobe.Result) database.MediaInfo {
+	info := database.MediaInfo{
+		VideoID:         videoID,
+		FormatName:      probe.FormatName,
+		DurationSeconds: probe.Duration.Seconds(),
+		BitRate:         probe.BitRate,
+		Size:            probe.Size,
+		Streams:         []database.MediaStream{},
+	}
+
+	if video, ok := probe.FirstVideo(); ok {
+		info.VideoCodec = &video.CodecName
+		info.Width = &video.Width
+		info.Height = &video.Height
+		info.FrameRate = &video.FrameRate
+		info.Rotation = &video.Rotation
+	}
+	if audio, ok := probe.FirstAudio(); ok {
+		info.AudioCodec = &audio.CodecName
+		info.ChannelLayout = &audio.ChannelLayout
+		info.SampleRate = &audio.SampleRate
+	}
+
+	for _, s := range probe.Streams {
+		info.Streams = append(info.Streams, database.MediaStream{
+			Index:             s.Index,
+			CodecType:         s.CodecType,
+			CodecName:         s.CodecName,
+			Profile:           s.Profile,
+			BitRate:           s.BitRate,
+			Width:             s.Width,
+			Height:            s.Height,
+			FrameRate:         s.FrameRate,
+			Rotation:          s.Rotation,
+			SampleAspectRatio: s.SampleAspectRatio,
+			PixelFormat:       s.PixelFormat,
+			ChannelLayout:     s.ChannelLayout,
+			Channels:          s.Channels,
+			SampleRate:        s.SampleRate,
+		})
+	}
+	return info
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const jobTypeProcessVideo = "process_video"
+
+// processVideoPayload points at the untouched upload in the video store. It
+// is removed once processing succeeds or the video turns out to be deleted.
+type processVideoPayload struct {
+	RawKey    string `json:"raw_key"`
+	MediaType string `json:"media_type"`
+}
+
+type jobAcceptedResponse struct {
+	JobID     string `json:"job_id"`
+	StatusURL string `json:"status_url"`
+}
+
+func (cfg *apiConfig) handleProcessVideoJob(ctx context.Context, job database.Job) error {
+	var payload processVideoPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return err
+	}
+	if job.VideoID == nil {
+		return fmt.Errorf("job %s has no video", job.ID)
+	}
+
+	video, err := cfg.db.GetVideo(*job.VideoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil || video.Status == database.VideoStatusDeleted {
+		// Nothing left to process for, but the raw upload would otherwise
+		// stay behind. Failing to delete it retries the job.
+		err = cfg.videoStore.Delete(ctx, payload.RawKey)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("couldn't delete raw upload %s: %w", payload.RawKey, err)
+		}
+		return nil
+	}
+
+	err = cfg.processRawUpload(ctx, video, payload)
+	if err != nil {
+		if job.Attempts >= job.MaxAttempts {
+			cfg.markVideoFailed(video.ID, err.Error())
+		}
+		return err
+	}
+
+	err = cfg.db.SetVideoStatus(video.ID, database.VideoStatusReady, "")
+	if err != nil {
+		return err
+	}
+
+	err = cfg.videoStore.Delete(ctx, payload.RawKey)
+	if err != nil {
+		log.Printf("Couldn't delete raw upload %s: %v", payload.RawKey, err)
+	}
+	return nil
+}
+
+func (cfg *apiConfig) processRawUpload(ctx context.Context, video database.Video, payload processVideoPayload) error {
+	tempPath, err := cfg.downloadToTemp(ctx, payload.RawKey)
+	if err != nil {
+		return fmt.Errorf("couldn't download raw upload: %w", err)
+	}
+	defer os.Remove(tempPath)
+
+	_, err = cfg.processAndStoreVideo(ctx, video, tempPath, payload.MediaType)
+	return err
+}
+
+func rawUploadPrefix(videoID uuid.UUID) string {
+	return fmt.Sprintf("raw/%s/", videoID)
+}
+
+// storeRawUpload keeps the unprocessed upload in the video store so a worker
+// on any instance can pick it up, even after a restart.
+func (cfg *apiConfig) storeRawUpload(ctx context.Context, video database.Video, body io.Reader, mediaType string) (string, error) {
+	randomID := make([]byte, 32)
+	_, err := rand.Read(randomID)
+	if err != nil {
+		return "", err
+	}
+	key := rawUploadPrefix(video.ID) + base64.RawURLEncoding.EncodeToString(randomID)
+
+	err = cfg.videoStore.Put(ctx, key, body, storage.PutOptions{
+		ContentType: mediaType,
+	})
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// enqueueVideoProcessing queues processing of rawKey and writes the 202
+// response pointing the client at the job.
+func (cfg *apiConfig) enqueueVideoProcessing(w http.ResponseWriter, video database.Video, rawKey, mediaType string) {
+	if !cfg.setVideoStatus(w, video.ID, database.VideoStatusProcessing) {
+		return
+	}
+
+	job, err := cfg.jobs.Enqueue(jobTypeProcessVideo, &video.ID, &video.UserID, processVideoPayload{
+		RawKey:    rawKey,
+		MediaType: mediaType,
+	})
+	if err != nil {
+		cfg.markVideoFailed(video.ID, "processing could not be queued")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue video processing", err)
+		return
+	}
+
+	statusURL := fmt.Sprintf("/api/jobs/%s", job.ID)
+	w.Header().Set("Location", statusURL)
+	respondWithJSON(w, http.StatusAccepted, jobAcceptedResponse{
+		JobID:     job.ID.String(),
+		StatusURL: statusURL,
+	})
+}
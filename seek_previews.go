@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mediaprobe"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+)
+
+const (
+	spriteTileWidth = 160
+	spriteColumns   = 10
+	spriteRows      = 10
+)
+
+// generateSeekPreviews builds the scrub bar sprite sheets and their WebVTT
+// track from source and stores them under prefix/previews, returning the
+// track's key.
+func (cfg *apiConfig) generateSeekPreviews(ctx context.Context, source, prefix string, probe mediaprobe.Result) (string, error) {
+	videoStream, ok := probe.FirstVideo()
+	if !ok {
+		return "", fmt.Errorf("no video stream found")
+	}
+	// ffmpeg applies the rotation before our filters run, so the tiles
+	// follow the displayed orientation.
+	width, height := videoStream.DisplaySize()
+	if width <= 0 || height <= 0 {
+		return "", fmt.Errorf("video stream has no dimensions")
+	}
+	tileHeight := int(float64(spriteTileWidth)*float64(height)/float64(width)/2+0.5) * 2
+
+	outDir, err := os.MkdirTemp("", "tubely-previews-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(outDir)
+
+	err = transcode.Sprites(ctx, source, outDir, probe.Duration, transcode.SpriteOptions{
+		Interval:   cfg.spriteInterval,
+		TileWidth:  spriteTileWidth,
+		TileHeight: max(tileHeight, 2),
+		Columns:    spriteColumns,
+		Rows:       spriteRows,
+	})
+	if err != nil {
+		return "", fmt.Errorf("couldn't generate sprites: %w", err)
+	}
+
+	previewPrefix := prefix + "/previews"
+	err = cfg.uploadDir(ctx, outDir, previewPrefix)
+	if err != nil {
+		return "", fmt.Errorf("couldn't upload sprites: %w", err)
+	}
+	return previewPrefix + "/" + transcode.SpriteVTT, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// mediaKind groups stored objects by how long their signed URLs should live.
+type mediaKind int
+
+const (
+	// mediaKindVideo is the progressive MP4.
+	mediaKindVideo mediaKind = iota
+	// mediaKindStream covers the HLS and DASH manifests and the preview
+	// track, which players keep using for a whole session.
+	mediaKindStream
+	// mediaKindImage covers thumbnails and animated previews.
+	mediaKindImage
+)
+
+// urlAudience is who a signed URL is handed to. Owners get the configured
+// lifetimes; anyone else fetching a video by ID gets the shared ones.
+type urlAudience int
+
+const (
+	audienceOwner urlAudience = iota
+	audienceShared
+)
+
+type urlLifetimes map[urlAudience]map[mediaKind]time.Duration
+
+func lifetimesFromEnv() urlLifetimes {
+	seconds := func(name string, def int) time.Duration {
+		return time.Duration(envInt(name, def)) * time.Second
+	}
+	return urlLifetimes{
+		audienceOwner: {
+			mediaKindVideo:  seconds("SIGNED_URL_TTL_VIDEO", 300),
+			mediaKindStream: seconds("SIGNED_URL_TTL_STREAM", 3600),
+			mediaKindImage:  seconds("SIGNED_URL_TTL_IMAGE", 3600),
+		},
+		audienceShared: {
+			mediaKindVideo:  seconds("SHARED_SIGNED_URL_TTL_VIDEO", 300),
+			mediaKindStream: seconds("SHARED_SIGNED_URL_TTL_STREAM", 900),
+			mediaKindImage:  seconds("SHARED_SIGNED_URL_TTL_IMAGE", 900),
+		},
+	}
+}
+
+type signedURL struct {
+	URL       string
+	ExpiresAt time.Time
+}
+
+type signedURLCacheKey struct {
+	bucket string
+	key    string
+	ttl    time.Duration
+}
+
+// signedURLCache reuses signatures across requests. A cached URL is handed
+// out while at least half its lifetime is left, so every caller still gets
+// a URL valid for a good share of what was configured, and a listing of
+// hundreds of videos signs each object at most once per half lifetime.
+type signedURLCache struct {
+	mu      sync.Mutex
+	entries map[signedURLCacheKey]signedURL
+}
+
+// maxSignedURLCacheEntries bounds memory; stale entries are swept out once
+// the cache grows past it.
+const maxSignedURLCacheEntries = 10000
+
+func newSignedURLCache() *signedURLCache {
+	return &signedURLCache{entries: map[signedURLCacheKey]signedURL{}}
+}
+
+func (c *signedURLCache) get(key signedURLCacheKey, now time.Time) (signedURL, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || entry.ExpiresAt.Sub(now) < key.ttl/2 {
+		return signedURL{}, false
+	}
+	return entry, true
+}
+
+func (c *signedURLCache) put(key signedURLCacheKey, entry signedURL, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxSignedURLCacheEntries {
+		for k, e := range c.entries {
+			if e.ExpiresAt.Sub(now) < k.ttl/2 {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxSignedURLCacheEntries {
+			c.entries = map[signedURLCacheKey]signedURL{}
+		}
+	}
+	c.entries[key] = entry
+}
+
+// signKey returns a URL for a key in the video store that stays valid for
+// ttl, or at least half of it when it comes from the cache.
+func (cfg *apiConfig) signKey(ctx context.Context, key string, ttl time.Duration) (signedURL, error) {
+	cacheKey := signedURLCacheKey{bucket: cfg.videoStore.Bucket(), key: key, ttl: ttl}
+	now := time.Now()
+	if cached, ok := cfg.signedURLs.get(cacheKey, now); ok {
+		return cached, nil
+	}
+
+	expiresAt := now.Add(ttl)
+	var url string
+	var err error
+	if cfg.deliveryMode == deliveryCloudFront {
+		url, err = cfg.cdnSigner.SignURL(cfg.cdnURL(key), expiresAt)
+	} else {
+		url, err = cfg.videoStore.PresignGet(ctx, key, ttl)
+	}
+	if err != nil {
+		return signedURL{}, err
+	}
+
+	signed := signedURL{URL: url, ExpiresAt: expiresAt}
+	cfg.signedURLs.put(cacheKey, signed, now)
+	return signed, nil
+}
+
+// videoAudience works out whether the caller owns video. The token is
+// optional here, a missing or invalid one just means a shared viewer.
+func (cfg *apiConfig) videoAudience(r *http.Request, video database.Video) urlAudience {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return audienceShared
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil || userID != video.UserID {
+		return audienceShared
+	}
+	return audienceOwner
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+func (cfg *apiConfig) configureStorage() error {
+	bucket := cfg.s3Bucket
+	if bucket == "" {
+		bucket = cfg.storageBackend
+	}
+
+	switch cfg.storageBackend {
+	case "s3":
+		awsConfig, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(cfg.s3Region))
+		if err != nil {
+			return err
+		}
+		cfg.s3Client = s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+			if cfg.s3Endpoint != "" {
+				o.BaseEndpoint = aws.String(cfg.s3Endpoint)
+				o.UsePathStyle = true
+			}
+		})
+		cfg.videoStore = storage.NewS3Store(cfg.s3Client, bucket, cfg.s3Options)
+	case "local":
+		videoStore, err := storage.NewLocalStore(bucket, cfg.assetsRoot, cfg.publicBaseURL+"/assets")
+		if err != nil {
+			return err
+		}
+		cfg.videoStore = videoStore
+	case "memory":
+		cfg.videoStore = storage.NewMemoryStore(bucket, cfg.publicBaseURL+"/media")
+	default:
+		return fmt.Errorf("unknown storage backend %q", cfg.storageBackend)
+	}
+	return nil
+}
+
+// downloadToTemp copies an object from the video store into a local temp file
+// so ffmpeg and ffprobe can work on it. The caller removes the file.
+func (cfg *apiConfig) downloadToTemp(ctx context.Context, key string) (string, error) {
+	body, _, err := cfg.videoStore.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	tempFile, err := os.CreateTemp("", "tubely-download-*")
+	if err != nil {
+		return "", err
+	}
+	defer tempFile.Close()
+
+	if _, err := io.Copy(tempFile, body); err != nil {
+		os.Remove(tempFile.Name())
+		return "", err
+	}
+	return tempFile.Name(), nil
+}
+
+// Extensions the mime package doesn't know on every system.
+var mediaContentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".mpd":  "application/dash+xml",
+	".m4s":  "video/iso.segment",
+	".vtt":  "text/vtt",
+	".webp": "image/webp",
+}
+
+func contentTypeForKey(key string) string {
+	ext := path.Ext(key)
+	if contentType, ok := mediaContentTypes[ext]; ok {
+		return contentType
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// uploadDir stores every file below dir in the video store, keyed by its
+// path relative to dir under prefix.
+func (cfg *apiConfig) uploadDir(ctx context.Context, dir, prefix string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		key := prefix + "/" + filepath.ToSlash(rel)
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		stat, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		return cfg.videoStore.Put(ctx, key, f, storage.PutOptions{
+			ContentType: contentTypeForKey(key),
+			Size:        stat.Size(),
+		})
+	})
+}
+
+// deleteStoredPrefix removes every object in the video store under prefix.
+func (cfg *apiConfig) deleteStoredPrefix(ctx context.Context, prefix string) error {
+	objects, err := cfg.videoStore.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if err := cfg.videoStore.Delete(ctx, obj.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteStoredPrefixExcept removes the objects under prefix that don't start
+// with keep. It only logs failures, the replacement is already in place.
+func (cfg *apiConfig) deleteStoredPrefixExcept(ctx context.Context, prefix, keep string) {
+	objects, err := cfg.videoStore.List(ctx, prefix)
+	if err != nil {
+		log.Printf("Couldn't list %s: %v", prefix, err)
+		return
+	}
+	for _, obj := range objects {
+		if strings.HasPrefix(obj.Key, keep) {
+			continue
+		}
+		if err := cfg.videoStore.Delete(ctx, obj.Key); err != nil {
+			log.Printf("Couldn't delete %s: %v", obj.Key, err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	storageDeletionBatch      = 100
+	storageDeletionBaseDelay  = 10 * time.Second
+	storageDeletionMaxBackoff = time.Hour
+)
+
+// videoStorageDeletions lists everything stored for a video: the MP4 and the
+// streaming packages and seek previews under its key, the thumbnails, their
+// candidates, the animated preview and any raw or direct upload still
+// waiting.
+func (cfg *apiConfig) videoStorageDeletions(video database.Video) []database.CreateStorageDeletionParams {
+	deletion := func(store, key string) database.CreateStorageDeletionParams {
+		return database.CreateStorageDeletionParams{
+			VideoID: video.ID,
+			Store:   store,
+			Key:     key,
+		}
+	}
+
+	deletions := []database.CreateStorageDeletionParams{
+		deletion(database.StorageDeletionVideoStore, thumbnailPrefix(video.ID)),
+		deletion(database.StorageDeletionVideoStore, thumbnailCandidatePrefix(video.ID)),
+		deletion(database.StorageDeletionVideoStore, animatedPreviewPrefix(video.ID)),
+		deletion(database.StorageDeletionVideoStore, rawUploadPrefix(video.ID)),
+		deletion(database.StorageDeletionVideoStore, directUploadPrefix(video.ID)),
+	}
+	if video.Storage != nil && video.Storage.Bucket == cfg.videoStore.Bucket() {
+		key := video.Storage.Key
+		deletions = append(deletions,
+			deletion(database.StorageDeletionVideoStore, key),
+			deletion(database.StorageDeletionVideoStore, strings.TrimSuffix(key, path.Ext(key))+"/"),
+		)
+	}
+	if name, ok := cfg.legacyThumbnailFile(video.LegacyThumbnailURL); ok {
+		deletions = append(deletions, deletion(database.StorageDeletionAssets, name))
+	}
+	return deletions
+}
+
+// legacyThumbnailFile finds the file in the assets directory behind a
+// thumbnail URL from before thumbnails moved into the video store.
+func (cfg *apiConfig) legacyThumbnailFile(thumbnailURL *string) (string, bool) {
+	if thumbnailURL == nil {
+		return "", false
+	}
+	u, err := url.Parse(*thumbnailURL)
+	if err != nil {
+		return "", false
+	}
+	dir, name := path.Split(u.Path)
+	assetsDir := "/" + filepath.ToSlash(filepath.Clean(cfg.assetsRoot)) + "/"
+	if name == "" || (dir != assetsDir && dir != "/assets/") {
+		return "", false
+	}
+	return name, true
+}
+
+// deleteVideoObjects queues removal of a video's stored objects and wakes
+// the deletion worker.
+func (cfg *apiConfig) deleteVideoObjects(video database.Video) error {
+	err := cfg.db.DeleteVideo(video.ID, cfg.videoStorageDeletions(video))
+	if err != nil {
+		return err
+	}
+	select {
+	case cfg.deletionWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// runStorageDeletions works through the storage deletion outbox until ctx is
+// cancelled. Failed deletes are retried with backoff and never dropped.
+func (cfg *apiConfig) runStorageDeletions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		deletions, err := cfg.db.GetDueStorageDeletions(storageDeletionBatch)
+		if err != nil {
+			log.Printf("Couldn't get storage deletions: %v", err)
+		}
+		for _, deletion := range deletions {
+			if ctx.Err() != nil {
+				return
+			}
+			cfg.runStorageDeletion(ctx, deletion)
+		}
+
+		// A full batch likely means more are due.
+		if len(deletions) == storageDeletionBatch {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-cfg.deletionWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) runStorageDeletion(ctx context.Context, deletion database.StorageDeletion) {
+	deleteErr := cfg.deleteStorageObjects(ctx, deletion.Store, deletion.Key)
+	if deleteErr == nil {
+		err := cfg.db.CompleteStorageDeletion(deletion.ID)
+		if err != nil {
+			log.Printf("Couldn't complete storage deletion %s: %v", deletion.ID, err)
+		}
+		return
+	}
+
+	backoff := storageDeletionBaseDelay << deletion.Attempts
+	if backoff > storageDeletionMaxBackoff || backoff <= 0 {
+		backoff = storageDeletionMaxBackoff
+	}
+	log.Printf("Deleting %s %q for video %s failed, retrying in %s: %v", deletion.Store, deletion.Key, deletion.VideoID, backoff, deleteErr)
+	err := cfg.db.RetryStorageDeletion(deletion.ID, deleteErr.Error(), time.Now().Add(backoff))
+	if err != nil {
+		log.Printf("Couldn't record failed storage deletion %s: %v", deletion.ID, err)
+	}
+}
+
+func (cfg *apiConfig) deleteStorageObjects(ctx context.Context, store, key string) error {
+	switch store {
+	case database.StorageDeletionVideoStore:
+		if strings.HasSuffix(key, "/") {
+			return cfg.deleteStoredPrefix(ctx, key)
+		}
+		return cfg.videoStore.Delete(ctx, key)
+	case database.StorageDeletionAssets:
+		if key != filepath.Base(key) {
+			return fmt.Errorf("invalid asset name %q", key)
+		}
+		err := os.Remove(filepath.Join(cfg.assetsRoot, key))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown store %q", store)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/imageproc"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+	"github.com/google/uuid"
+)
+
+// thumbnailWidths are the rendition sizes offered to the frontend's srcset.
+var thumbnailWidths = []int{160, 320, 640, 1280}
+
+const (
+	thumbnailJPEGQuality = 85
+	thumbnailWebPQuality = 80
+
+	// The placeholder is a tiny blurry JPEG inlined as a data URL; browsers
+	// scale it up to the thumbnail's box while the real image loads.
+	placeholderWidth    = 16
+	placeholderQuality  = 50
+	blurHashXComponents = 4
+	blurHashYComponents = 3
+)
+
+func thumbnailPrefix(videoID uuid.UUID) string {
+	return fmt.Sprintf("thumbnails/%s/", videoID)
+}
+
+// storeThumbnail decodes an uploaded or generated thumbnail and stores it
+// without its metadata as JPEG and WebP renditions at each of
+// thumbnailWidths. The video's previous renditions are left for the caller
+// to remove once the video points at the new ones.
+func (cfg *apiConfig) storeThumbnail(ctx context.Context, videoID uuid.UUID, data []byte) (database.Thumbnail, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return database.Thumbnail{}, fmt.Errorf("couldn't decode thumbnail: %w", err)
+	}
+	// Only pixels survive from here on: the renditions are encoded fresh,
+	// so EXIF (GPS, camera details), XMP and ICC blocks of the upload are
+	// never stored. The orientation is the one piece of metadata that
+	// changes how the image looks, so it is baked in first.
+	// The result is the only full size copy: every rendition and placeholder
+	// is scaled from it.
+	src := imageproc.ApplyOrientation(img, imageproc.Orientation(data))
+
+	randomID := make([]byte, 32)
+	_, err = rand.Read(randomID)
+	if err != nil {
+		return database.Thumbnail{}, fmt.Errorf("couldn't generate random ID: %w", err)
+	}
+	// A fresh directory per upload, so cached URLs of the old thumbnail never
+	// serve the new one.
+	prefix := thumbnailPrefix(videoID) + base64.RawURLEncoding.EncodeToString(randomID) + "/"
+
+	tempDir, err := os.MkdirTemp("", "tubely-thumbnail-*")
+	if err != nil {
+		return database.Thumbnail{}, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Flattening after scaling gives the same pixels for a fraction of the
+	// work.
+	var placeholder bytes.Buffer
+	err = jpeg.Encode(&placeholder, imageproc.Flatten(imageproc.Resize(src, placeholderWidth), color.White), &jpeg.Options{Quality: placeholderQuality})
+	if err != nil {
+		return database.Thumbnail{}, err
+	}
+
+	result := database.Thumbnail{
+		Bucket:   cfg.videoStore.Bucket(),
+		Prefix:   prefix,
+		BlurHash: imageproc.BlurHash(imageproc.Flatten(imageproc.Resize(src, imageproc.BlurHashSampleWidth), color.White), blurHashXComponents, blurHashYComponents),
+		LQIP:     "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(placeholder.Bytes()),
+	}
+	for _, width := range renditionWidths(src.Bounds().Dx()) {
+		resized := imageproc.Resize(src, width)
+
+		var jpegData bytes.Buffer
+		err = jpeg.Encode(&jpegData, imageproc.Flatten(resized, color.White), &jpeg.Options{Quality: thumbnailJPEGQuality})
+		if err != nil {
+			return database.Thumbnail{}, err
+		}
+		rendition := database.ThumbnailRendition{
+			Name:        fmt.Sprintf("%d.jpg", width),
+			ContentType: "image/jpeg",
+			Width:       width,
+		}
+		err = cfg.putThumbnailRendition(ctx, result.Key(rendition), jpegData.Bytes(), rendition.ContentType)
+		if err != nil {
+			return database.Thumbnail{}, err
+		}
+		result.Renditions = append(result.Renditions, rendition)
+
+		// The standard library has no WebP encoder, so ffmpeg converts a
+		// lossless PNG of the rendition. WebP is a nice to have, JPEG is
+		// always there to fall back on.
+		webpData, err := encodeWebP(ctx, tempDir, resized)
+		if err != nil {
+			log.Printf("Couldn't encode %dw WebP thumbnail: %v", width, err)
+			continue
+		}
+		rendition = database.ThumbnailRendition{
+			Name:        fmt.Sprintf("%d.webp", width),
+			ContentType: "image/webp",
+			Width:       width,
+		}
+		err = cfg.putThumbnailRendition(ctx, result.Key(rendition), webpData, rendition.ContentType)
+		if err != nil {
+			return database.Thumbnail{}, err
+		}
+		result.Renditions = append(result.Renditions, rendition)
+	}
+
+	return result, nil
+}
+
+// renditionWidths drops the widths larger than the source so nothing is
+// upscaled. A source narrower than all of them is kept at its own width.
+func renditionWidths(sourceWidth int) []int {
+	widths := []int{}
+	for _, w := range thumbnailWidths {
+		if w <= sourceWidth {
+			widths = append(widths, w)
+		}
+	}
+	if len(widths) == 0 {
+		widths = append(widths, sourceWidth)
+	}
+	return widths
+}
+
+func (cfg *apiConfig) putThumbnailRendition(ctx context.Context, key string, data []byte, mediaType string) error {
+	return cfg.videoStore.Put(ctx, key, bytes.NewReader(data), storage.PutOptions{
+		ContentType: mediaType,
+		Size:        int64(len(data)),
+	})
+}
+
+func encodeWebP(ctx context.Context, tempDir string, img image.Image) ([]byte, error) {
+	input := filepath.Join(tempDir, "rendition.png")
+	output := filepath.Join(tempDir, "rendition.webp")
+	f, err := os.Create(input)
+	if err != nil {
+		return nil, err
+	}
+	err = png.Encode(f, img)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	err = transcode.EncodeWebP(ctx, input, output, thumbnailWebPQuality)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(output)
+}
+
+// deleteReplacedThumbnail removes the renditions of a thumbnail the video no
+// longer points at. It only logs failures, the replacement is already in
+// place.
+func (cfg *apiConfig) deleteReplacedThumbnail(ctx context.Context, replaced *database.Thumbnail) {
+	if replaced == nil || replaced.Bucket != cfg.videoStore.Bucket() {
+		return
+	}
+	for _, rendition := range replaced.Renditions {
+		key := replaced.Key(rendition)
+		if err := cfg.videoStore.Delete(ctx, key); err != nil {
+			log.Printf("Couldn't delete %s: %v", key, err)
+		}
+	}
+}
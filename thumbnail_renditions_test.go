@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// Orientation itself is covered by the imageproc tests; these check what
+// storeThumbnail writes.
+
+// maxLQIPLength bounds the placeholder data URL. Most of a 16px JPEG is its
+// Huffman and quantization tables, which is why this isn't smaller.
+const maxLQIPLength = 1536
+
+// exifWithOrientation is a big-endian TIFF header with a single IFD0 entry
+// setting the orientation to 1.
+var exifWithOrientation = []byte{
+	'M', 'M', 0, 42, 0, 0, 0, 8,
+	0, 1,
+	0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, 1, 0, 0,
+	0, 0, 0, 0,
+}
+
+const testXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/"/>`
+
+// testImage is an upload carrying EXIF, XMP and ICC blocks.
+func testImage(t *testing.T, format string, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	icc := bytes.Repeat([]byte("ICC profile data "), 8)
+
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg":
+		if err := jpeg.Encode(&buf, img, nil); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+		segment := func(marker byte, payload []byte) []byte {
+			s := []byte{0xff, marker, 0, 0}
+			binary.BigEndian.PutUint16(s[2:], uint16(len(payload)+2))
+			return append(s, payload...)
+		}
+		out := append([]byte{}, data[:2]...)
+		out = append(out, segment(0xe1, append([]byte("Exif\x00\x00"), exifWithOrientation...))...)
+		out = append(out, segment(0xe1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), testXMP...))...)
+		out = append(out, segment(0xe2, append([]byte("ICC_PROFILE\x00\x01\x01"), icc...))...)
+		return append(out, data[2:]...)
+	case "png":
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+		chunk := func(chunkType string, payload []byte) []byte {
+			c := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+			c = append(c, chunkType...)
+			c = append(c, payload...)
+			return binary.BigEndian.AppendUint32(c, crc32.ChecksumIEEE(c[4:]))
+		}
+		// The signature and IHDR come first.
+		const header = 8 + 12 + 13
+		out := append([]byte{}, data[:header]...)
+		out = append(out, chunk("eXIf", exifWithOrientation)...)
+		out = append(out, chunk("iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), testXMP...))...)
+		return append(out, data[header:]...)
+	}
+	t.Fatalf("unknown format %s", format)
+	return nil
+}
+
+func TestStoreThumbnail(t *testing.T) {
+	tests := []struct {
+		format string
+		width  int
+		want   []string
+	}{
+		{"jpeg", 700, []string{"160.jpg", "320.jpg", "640.jpg"}},
+		{"png", 1500, []string{"160.jpg", "320.jpg", "640.jpg", "1280.jpg"}},
+		{"jpeg", 100, []string{"100.jpg"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %dw", tt.format, tt.width), func(t *testing.T) {
+			store := storage.NewMemoryStore("thumbnails-test", "http://localhost/media")
+			cfg := &apiConfig{videoStore: store}
+			ctx := context.Background()
+			videoID := uuid.New()
+
+			thumbnail, err := cfg.storeThumbnail(ctx, videoID, testImage(t, tt.format, tt.width, tt.width/2))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(thumbnail.Prefix, thumbnailPrefix(videoID)) || !strings.HasSuffix(thumbnail.Prefix, "/") {
+				t.Errorf("prefix %q is not a directory under %q", thumbnail.Prefix, thumbnailPrefix(videoID))
+			}
+
+			// WebP needs ffmpeg, so only the JPEG renditions are certain.
+			names := []string{}
+			for _, rendition := range thumbnail.Renditions {
+				key := thumbnail.Key(rendition)
+				body, _, err := store.Get(ctx, key)
+				if err != nil {
+					t.Fatalf("%s: %v", key, err)
+				}
+				stored, err := io.ReadAll(body)
+				body.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkNoMetadata(t, key, stored)
+				if rendition.ContentType == "image/jpeg" {
+					names = append(names, rendition.Name)
+				}
+			}
+			if strings.Join(names, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("JPEG renditions %v, want %v", names, tt.want)
+			}
+
+			lqip, ok := strings.CutPrefix(thumbnail.LQIP, "data:image/jpeg;base64,")
+			if !ok {
+				t.Fatalf("LQIP %q is not a JPEG data URL", thumbnail.LQIP)
+			}
+			placeholder, err := base64.StdEncoding.DecodeString(lqip)
+			if err != nil {
+				t.Fatalf("LQIP: %v", err)
+			}
+			checkNoMetadata(t, "LQIP", placeholder)
+			// The LQIP is inlined into every listing, so it has to stay a
+			// tiny image.
+			config, err := jpeg.DecodeConfig(bytes.NewReader(placeholder))
+			if err != nil {
+				t.Fatalf("LQIP: %v", err)
+			}
+			if config.Width != placeholderWidth {
+				t.Errorf("LQIP is %dpx wide, want %d", config.Width, placeholderWidth)
+			}
+			if len(thumbnail.LQIP) > maxLQIPLength {
+				t.Errorf("LQIP data URL is %d bytes, want at most %d", len(thumbnail.LQIP), maxLQIPLength)
+			}
+		})
+	}
+}
+
+// checkNoMetadata fails if a stored JPEG, PNG or WebP carries EXIF, XMP or
+// ICC data.
+func checkNoMetadata(t *testing.T, name string, data []byte) {
+	t.Helper()
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		// Walk the segments up to the start of scan. APP1 holds EXIF and
+		// XMP, APP2 the ICC profile.
+		for i := 2; i+4 <= len(data); {
+			if data[i] != 0xff {
+				t.Errorf("%s: malformed JPEG at offset %d", name, i)
+				return
+			}
+			marker := data[i+1]
+			if marker == 0xda {
+				return
+			}
+			if marker == 0xe1 || marker == 0xe2 {
+				t.Errorf("%s: has an APP%d segment", name, marker-0xe0)
+			}
+			i += 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		}
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		for i := 8; i+8 <= len(data); {
+			length := int(binary.BigEndian.Uint32(data[i:]))
+			switch chunkType := string(data[i+4 : i+8]); chunkType {
+			case "eXIf", "iTXt", "iCCP", "tEXt", "zTXt":
+				t.Errorf("%s: has an %s chunk", name, chunkType)
+			}
+			i += 12 + length
+		}
+	case bytes.HasPrefix(data, []byte("RIFF")) && len(data) >= 12 && string(data[8:12]) == "WEBP":
+		for i := 12; i+8 <= len(data); {
+			length := int(binary.LittleEndian.Uint32(data[i+4:]))
+			switch chunkType := string(data[i : i+4]); chunkType {
+			case "EXIF", "XMP ", "ICCP":
+				t.Errorf("%s: has an %s chunk", name, chunkType)
+			}
+			i += 8 + length + length%2
+		}
+	default:
+		t.Errorf("%s: unknown image format", name)
+	}
+}
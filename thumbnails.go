@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/jpeg"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/imageproc"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mediaprobe"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+	"github.com/google/uuid"
+)
+
+// Candidate frames are taken at these shares of the duration, plus up to
+// maxSceneCandidates frames at scene changes.
+var thumbnailPositions = []float64{0.1, 0.25, 0.5, 0.75, 0.9}
+
+const (
+	maxSceneCandidates = 4
+	sceneThreshold     = 0.4
+)
+
+func thumbnailCandidatePrefix(videoID uuid.UUID) string {
+	return fmt.Sprintf("thumbnails/candidates/%s/", videoID)
+}
+
+type candidateFrame struct {
+	path     string
+	source   string
+	position *float64
+}
+
+// generateThumbnailCandidates extracts candidate frames from source, scores
+// them and stores them as the video's candidates, replacing any from an
+// earlier upload. It returns the JPEG bytes of the best one.
+func (cfg *apiConfig) generateThumbnailCandidates(ctx context.Context, videoID uuid.UUID, source string, probe mediaprobe.Result) ([]byte, error) {
+	outDir, err := os.MkdirTemp("", "tubely-frames-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(outDir)
+
+	frames := []candidateFrame{}
+	for i, share := range thumbnailPositions {
+		at := time.Duration(float64(probe.Duration) * share)
+		output := filepath.Join(outDir, fmt.Sprintf("position_%02d.jpg", i))
+		if err := transcode.ExtractFrame(ctx, source, output, at); err != nil {
+			log.Printf("Couldn't extract frame at %s of video %s: %v", at, videoID, err)
+			continue
+		}
+		seconds := at.Seconds()
+		frames = append(frames, candidateFrame{path: output, source: "position", position: &seconds})
+	}
+
+	// Scene detection is a bonus; short or static videos may have no cuts.
+	err = transcode.ExtractSceneFrames(ctx, source, outDir, sceneThreshold, maxSceneCandidates)
+	if err != nil {
+		log.Printf("Couldn't extract scene frames of video %s: %v", videoID, err)
+	}
+	sceneFrames, _ := filepath.Glob(filepath.Join(outDir, "scene_*.jpg"))
+	sort.Strings(sceneFrames)
+	for _, path := range sceneFrames {
+		frames = append(frames, candidateFrame{path: path, source: "scene"})
+	}
+
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no frames could be extracted")
+	}
+
+	err = cfg.deleteThumbnailCandidates(ctx, videoID)
+	if err != nil {
+		return nil, err
+	}
+
+	var best []byte
+	bestScore := -1.0
+	for i, frame := range frames {
+		data, err := os.ReadFile(frame.path)
+		if err != nil {
+			return nil, err
+		}
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			log.Printf("Couldn't decode frame %s: %v", frame.path, err)
+			continue
+		}
+		quality := imageproc.Measure(img)
+		score := quality.Score()
+
+		key := fmt.Sprintf("%s%02d.jpg", thumbnailCandidatePrefix(videoID), i)
+		err = cfg.videoStore.Put(ctx, key, bytes.NewReader(data), storage.PutOptions{
+			ContentType: "image/jpeg",
+			Size:        int64(len(data)),
+		})
+		if err != nil {
+			return nil, err
+		}
+		_, err = cfg.db.CreateThumbnailCandidate(database.CreateThumbnailCandidateParams{
+			VideoID:         videoID,
+			StorageKey:      key,
+			Source:          frame.source,
+			PositionSeconds: frame.position,
+			Brightness:      quality.Brightness,
+			Contrast:        quality.Contrast,
+			Sharpness:       quality.Sharpness,
+			Score:           score,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if score > bestScore {
+			best = data
+			bestScore = score
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no usable frames")
+	}
+	return best, nil
+}
+
+func (cfg *apiConfig) deleteThumbnailCandidates(ctx context.Context, videoID uuid.UUID) error {
+	err := cfg.deleteStoredPrefix(ctx, thumbnailCandidatePrefix(videoID))
+	if err != nil {
+		return err
+	}
+	return cfg.db.DeleteThumbnailCandidates(videoID)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/validation"
+)
+
+var allowedThumbnailTypes = []string{"image/jpeg", "image/png"}
+
+// respondWithValidationError tells the client which rule the upload broke.
+// Errors that aren't rule failures are ours and get a plain 500.
+func respondWithValidationError(w http.ResponseWriter, err error) {
+	var verr *validation.Error
+	if !errors.As(err, &verr) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't validate upload", err)
+		return
+	}
+	type validationErrorResponse struct {
+		Error  string          `json:"error"`
+		Rule   validation.Rule `json:"rule"`
+		Limit  string          `json:"limit,omitempty"`
+		Actual string          `json:"actual,omitempty"`
+	}
+	respondWithJSON(w, verr.StatusCode(), validationErrorResponse{
+		Error:  "Upload rejected: " + verr.Message,
+		Rule:   verr.Rule,
+		Limit:  verr.Limit,
+		Actual: verr.Actual,
+	})
+}
+
+// validateVideoUpload checks a fully received upload at path before it is
+// handed to processing: against the limits, and that what it really is may be
+// uploaded. On failure it marks the video failed, writes the response and
+// returns false.
+func (cfg *apiConfig) validateVideoUpload(w http.ResponseWriter, r *http.Request, video database.Video, path, mediaType string) bool {
+	probe, err := validation.ValidateVideo(r.Context(), path, mediaType, cfg.uploadLimits)
+	if err == nil {
+		err = cfg.checkProbedType(probe, mediaType)
+	}
+	if err != nil {
+		cfg.markVideoFailed(video.ID, "upload rejected: "+err.Error())
+		respondWithValidationError(w, err)
+		return false
+	}
+	return true
+}
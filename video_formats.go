@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mediaprobe"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/validation"
+)
+
+type videoContainer struct {
+	ext string
+	// demuxer is the name ffprobe lists in format_name for this container.
+	demuxer string
+}
+
+// videoContainers are the upload types processing knows how to handle.
+// ALLOWED_VIDEO_TYPES narrows them down further.
+var videoContainers = map[string]videoContainer{
+	"video/mp4":        {ext: "mp4", demuxer: "mp4"},
+	"video/quicktime":  {ext: "mov", demuxer: "mov"},
+	"video/webm":       {ext: "webm", demuxer: "webm"},
+	"video/x-matroska": {ext: "mkv", demuxer: "matroska"},
+}
+
+const defaultAllowedVideoTypes = "video/mp4,video/quicktime,video/webm,video/x-matroska"
+
+// parseAllowedVideoTypes reads the comma separated allowlist, rejecting
+// types processing couldn't handle anyway.
+func parseAllowedVideoTypes(value string) (map[string]bool, error) {
+	allowed := map[string]bool{}
+	for _, mediaType := range strings.Split(value, ",") {
+		mediaType = strings.TrimSpace(mediaType)
+		if mediaType == "" {
+			continue
+		}
+		if _, ok := videoContainers[mediaType]; !ok {
+			return nil, fmt.Errorf("unsupported video type %q", mediaType)
+		}
+		allowed[mediaType] = true
+	}
+	if len(allowed) == 0 {
+		return nil, fmt.Errorf("no video types allowed")
+	}
+	return allowed, nil
+}
+
+func (cfg *apiConfig) videoTypeAllowed(mediaType string) bool {
+	return cfg.allowedVideoTypes[mediaType]
+}
+
+// allowedVideoTypeList is the allowlist as rejections report it.
+func (cfg *apiConfig) allowedVideoTypeList() string {
+	allowed := []string{}
+	for mediaType := range cfg.allowedVideoTypes {
+		allowed = append(allowed, mediaType)
+	}
+	slices.Sort(allowed)
+	return strings.Join(allowed, ", ")
+}
+
+// checkDeclaredType parses the content type a client declared for an upload
+// and returns its media type if that may be uploaded.
+func (cfg *apiConfig) checkDeclaredType(contentType string) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !cfg.videoTypeAllowed(mediaType) {
+		return "", &validation.Error{
+			Rule:    validation.RuleContentType,
+			Message: "video type is not allowed",
+			Limit:   cfg.allowedVideoTypeList(),
+			Actual:  contentType,
+		}
+	}
+	return mediaType, nil
+}
+
+// verifyContainer checks that what ffprobe found matches the type the client
+// claimed, since the header alone proves nothing.
+func verifyContainer(probe mediaprobe.Result, mediaType string) error {
+	container, ok := videoContainers[mediaType]
+	if !ok {
+		return fmt.Errorf("unsupported video type %q", mediaType)
+	}
+	for _, name := range strings.Split(probe.FormatName, ",") {
+		if name == container.demuxer {
+			return nil
+		}
+	}
+	return &validation.Error{
+		Rule:    validation.RuleContentType,
+		Message: "file contents don't match the declared content type",
+		Limit:   mediaType,
+		Actual:  probe.FormatName,
+	}
+}
+
+// checkProbedType rejects an upload unless ffprobe found the container it
+// claims to be and that container is allowed. A MOV declared as video/mp4
+// passes the container check, so the allowlist is applied to what was found
+// rather than to what was declared.
+func (cfg *apiConfig) checkProbedType(probe mediaprobe.Result, mediaType string) error {
+	err := verifyContainer(probe, mediaType)
+	if err != nil {
+		return err
+	}
+	probed := probedContentType(probe)
+	if !cfg.videoTypeAllowed(probed) {
+		return &validation.Error{
+			Rule:    validation.RuleContentType,
+			Message: "video type is not allowed",
+			Limit:   cfg.allowedVideoTypeList(),
+			Actual:  probed,
+		}
+	}
+	return nil
+}
+
+// probedContentType is the container ffprobe actually found, whatever the
+// client claimed. MOV and MP4 differ only in their brand, WebM and Matroska
+// only in the codecs WebM allows.
+func probedContentType(probe mediaprobe.Result) string {
+	names := strings.Split(probe.FormatName, ",")
+	switch {
+	case slices.Contains(names, "mov"):
+		if strings.TrimSpace(probe.MajorBrand) == "qt" {
+			return "video/quicktime"
+		}
+		return "video/mp4"
+	case slices.Contains(names, "matroska"):
+		for _, stream := range probe.Streams {
+			switch stream.CodecName {
+			case "vp8", "vp9", "av1", "vorbis", "opus":
+			default:
+				return "video/x-matroska"
+			}
+		}
+		return "video/webm"
+	default:
+		return probe.FormatName
+	}
+}
+
+// needsNormalization reports whether the streams have to be re-encoded for
+// players: anything but H.264 video with AAC audio, if any. The container
+// alone says nothing, a MOV or even an "MP4" may carry ProRes or HEVC.
+func needsNormalization(probe mediaprobe.Result) bool {
+	videoStream, ok := probe.FirstVideo()
+	if !ok || videoStream.CodecName != "h264" {
+		return true
+	}
+	audioStream, hasAudio := probe.FirstAudio()
+	return hasAudio && audioStream.CodecName != "aac"
+}
+
+// normalizeToMP4 converts an upload at path to an H.264/AAC MP4 next to it,
+// copying whichever stream already fits, and returns the new path.
+func normalizeToMP4(ctx context.Context, path string, probe mediaprobe.Result) (string, error) {
+	videoStream, ok := probe.FirstVideo()
+	if !ok {
+		return "", fmt.Errorf("no video stream found")
+	}
+	audioStream, hasAudio := probe.FirstAudio()
+
+	output := path + ".normalized.mp4"
+	err := transcode.NormalizeMP4(ctx, path, output,
+		videoStream.CodecName == "h264",
+		!hasAudio || audioStream.CodecName == "aac",
+	)
+	if err != nil {
+		return "", fmt.Errorf("couldn't normalize video: %w", err)
+	}
+	return output, nil
+}
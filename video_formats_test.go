@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mediaprobe"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/validation"
+)
+
+// ffprobe -show_format -show_streams output, trimmed to what is read.
+const (
+	quickTimeProbe = `{
+	"streams": [
+		{"index": 0, "codec_name": "h264", "codec_type": "video", "width": 1920, "height": 1080, "avg_frame_rate": "30/1"},
+		{"index": 1, "codec_name": "aac", "codec_type": "audio", "channels": 2, "sample_rate": "48000"}
+	],
+	"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "10.0", "tags": {"major_brand": "qt  "}}
+}`
+	mp4Probe = `{
+	"streams": [
+		{"index": 0, "codec_name": "h264", "codec_type": "video", "width": 1920, "height": 1080, "avg_frame_rate": "30/1"}
+	],
+	"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "10.0", "tags": {"major_brand": "isom"}}
+}`
+	matroskaProbe = `{
+	"streams": [
+		{"index": 0, "codec_name": "h264", "codec_type": "video", "width": 1280, "height": 720, "avg_frame_rate": "25/1"}
+	],
+	"format": {"format_name": "matroska,webm", "duration": "10.0"}
+}`
+)
+
+func TestCheckProbedType(t *testing.T) {
+	tests := []struct {
+		name     string
+		allowed  string
+		probe    string
+		declared string
+		reject   bool
+	}{
+		{"quicktime declared as mp4", "video/mp4", quickTimeProbe, "video/mp4", true},
+		{"quicktime allowed", "video/mp4,video/quicktime", quickTimeProbe, "video/mp4", false},
+		{"mp4", "video/mp4", mp4Probe, "video/mp4", false},
+		{"matroska declared as webm", "video/webm", matroskaProbe, "video/webm", true},
+		{"mp4 declared as webm", "video/mp4,video/webm", mp4Probe, "video/webm", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, err := parseAllowedVideoTypes(tt.allowed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := &apiConfig{allowedVideoTypes: allowed}
+			probe, err := mediaprobe.Parse([]byte(tt.probe))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = cfg.checkProbedType(probe, tt.declared)
+			if !tt.reject {
+				if err != nil {
+					t.Fatalf("rejected: %v", err)
+				}
+				return
+			}
+			var verr *validation.Error
+			if !errors.As(err, &verr) {
+				t.Fatalf("got %v, want a validation error", err)
+			}
+			if verr.Rule != validation.RuleContentType {
+				t.Errorf("rule = %s, want %s", verr.Rule, validation.RuleContentType)
+			}
+		})
+	}
+}
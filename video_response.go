@@ -0,0 +1,106 @@
+package main
+
+import (
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// videoResponse is a video as clients see it. Stored references are replaced
+// with URLs they can fetch, and where the media lives is never exposed.
+type videoResponse struct {
+	ID                uuid.UUID         `json:"id"`
+	CreatedAt         time.Time         `json:"created_at"`
+	UpdatedAt         time.Time         `json:"updated_at"`
+	ThumbnailURL      *string           `json:"thumbnail_url"`
+	ThumbnailSrcset   map[string]string `json:"thumbnail_srcset"`
+	ThumbnailBlurHash *string           `json:"thumbnail_blurhash"`
+	ThumbnailLQIP     *string           `json:"thumbnail_lqip"`
+	VideoURL          *string           `json:"video_url"`
+	// VideoURLExpiresAt is when the signed VideoURL stops working.
+	VideoURLExpiresAt *time.Time          `json:"video_url_expires_at"`
+	ContentType       *string             `json:"content_type"`
+	HLSURL            *string             `json:"hls_url"`
+	DASHURL           *string             `json:"dash_url"`
+	PreviewTrackURL   *string             `json:"preview_track_url"`
+	PreviewURL        *string             `json:"preview_url"`
+	OriginalFormat    *string             `json:"original_format"`
+	AspectRatio       *float64            `json:"aspect_ratio"`
+	AspectBucket      *string             `json:"aspect_bucket"`
+	MediaInfo         *database.MediaInfo `json:"media_info"`
+	database.VideoStatusInfo
+	database.CreateVideoParams
+}
+
+// videoToResponse builds the response for a video, signing its media for the
+// lifetimes configured for audience.
+func (cfg *apiConfig) videoToResponse(video database.Video, audience urlAudience) (videoResponse, error) {
+	response := videoResponse{
+		ID:                video.ID,
+		CreatedAt:         video.CreatedAt,
+		UpdatedAt:         video.UpdatedAt,
+		ThumbnailURL:      video.LegacyThumbnailURL,
+		OriginalFormat:    video.OriginalFormat,
+		AspectRatio:       video.AspectRatio,
+		AspectBucket:      video.AspectBucket,
+		MediaInfo:         video.MediaInfo,
+		VideoStatusInfo:   video.VideoStatusInfo,
+		CreateVideoParams: video.CreateVideoParams,
+	}
+
+	if video.Thumbnail != nil {
+		thumbnailURL, srcset, err := cfg.resolveThumbnail(*video.Thumbnail, audience)
+		if err != nil {
+			return response, err
+		}
+		response.ThumbnailURL = &thumbnailURL
+		response.ThumbnailSrcset = srcset
+		if video.Thumbnail.BlurHash != "" {
+			response.ThumbnailBlurHash = &video.Thumbnail.BlurHash
+			response.ThumbnailLQIP = &video.Thumbnail.LQIP
+		}
+	}
+
+	if video.Storage != nil {
+		signed, err := cfg.signStorageRef(*video.Storage, mediaKindVideo, audience)
+		if err != nil {
+			return response, err
+		}
+		response.VideoURL = &signed.URL
+		response.VideoURLExpiresAt = &signed.ExpiresAt
+		contentType := video.Storage.ContentType
+		response.ContentType = &contentType
+
+		if video.PreviewKey != nil {
+			previewURL, err := cfg.resolveListingObject(database.StorageRef{
+				Bucket: video.Storage.Bucket,
+				Key:    *video.PreviewKey,
+			}, audience)
+			if err != nil {
+				return response, err
+			}
+			response.PreviewURL = &previewURL
+		}
+
+		// Players fetch variant playlists, segments and sprite sheets
+		// relative to these, which a private bucket would refuse, so they
+		// point at the API and it signs each file as it is requested.
+		streamURLs := []struct {
+			key *string
+			url **string
+		}{
+			{video.HLSKey, &response.HLSURL},
+			{video.DASHKey, &response.DASHURL},
+			{video.PreviewTrackKey, &response.PreviewTrackURL},
+		}
+		for _, u := range streamURLs {
+			if u.key == nil {
+				continue
+			}
+			url := cfg.packageURL(video, *u.key)
+			*u.url = &url
+		}
+	}
+	return response, nil
+}
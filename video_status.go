@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// setVideoStatus applies a status transition on behalf of a handler. When the
+// video's current status doesn't allow it the client gets a 409.
+func (cfg *apiConfig) setVideoStatus(w http.ResponseWriter, videoID uuid.UUID, status database.VideoStatus) bool {
+	err := cfg.db.SetVideoStatus(videoID, status, "")
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidTransition) {
+			respondWithError(w, http.StatusConflict, "Video can't be "+string(status)+" right now", err)
+			return false
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video status", err)
+		return false
+	}
+	return true
+}
+
+// markVideoFailed records a failure without a response to write, e.g. from a
+// background job. Errors are only logged since the caller is already failing.
+func (cfg *apiConfig) markVideoFailed(videoID uuid.UUID, reason string) {
+	err := cfg.db.SetVideoStatus(videoID, database.VideoStatusFailed, reason)
+	if err != nil {
+		log.Printf("Couldn't mark video %s as failed: %v", videoID, err)
+	}
+}
+
+// uploadExpirySweep is how often stale uploads are looked for.
+const uploadExpirySweep = 15 * time.Minute
+
+// runUploadExpiry fails videos whose upload was abandoned, so they don't sit
+// in uploading forever, and removes the uploads no video is waiting for
+// anymore, until ctx is cancelled.
+func (cfg *apiConfig) runUploadExpiry(ctx context.Context, maxAge time.Duration) {
+	ticker := time.NewTicker(uploadExpirySweep)
+	defer ticker.Stop()
+
+	for {
+		expired, err := cfg.db.ExpireStaleUploads(time.Now().Add(-maxAge), "upload expired")
+		if err != nil {
+			log.Printf("Couldn't expire stale uploads: %v", err)
+		} else if expired > 0 {
+			log.Printf("Expired %d stale uploads", expired)
+		}
+		cfg.removeAbandonedUploads()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// removeAbandonedUploads deletes the partial files, locks and rows of uploads
+// whose video expired, failed or was deleted. A file that can't be removed
+// keeps its row so the next sweep tries again.
+func (cfg *apiConfig) removeAbandonedUploads() {
+	uploads, err := cfg.db.GetAbandonedUploads()
+	if err != nil {
+		log.Printf("Couldn't get abandoned uploads: %v", err)
+		return
+	}
+	for _, upload := range uploads {
+		err := os.Remove(upload.Path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Couldn't remove upload %s: %v", upload.ID, err)
+			continue
+		}
+		uploadLocks.Delete(upload.ID)
+		err = cfg.db.DeleteUpload(upload.ID)
+		if err != nil {
+			log.Printf("Couldn't delete upload %s: %v", upload.ID, err)
+		}
+	}
+}
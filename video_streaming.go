@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mediaprobe"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+)
+
+type streamingKeys struct {
+	HLS  string
+	DASH string
+}
+
+// generateStreamingPackages transcodes the source into the rendition ladder
+// for every enabled format and stores each package under its own directory
+// below prefix, returning the manifest keys.
+func (cfg *apiConfig) generateStreamingPackages(ctx context.Context, source, prefix string, probe mediaprobe.Result) (streamingKeys, error) {
+	keys := streamingKeys{}
+	if !cfg.hlsEnabled && !cfg.dashEnabled {
+		return keys, nil
+	}
+
+	videoStream, ok := probe.FirstVideo()
+	if !ok {
+		return keys, fmt.Errorf("no video stream found")
+	}
+	_, hasAudio := probe.FirstAudio()
+	width, height := videoStream.DisplaySize()
+	ladder := transcode.LadderFor(transcode.DefaultLadder, width, height)
+
+	formats := []struct {
+		enabled  bool
+		dir      string
+		manifest string
+		key      *string
+		build    func(ctx context.Context, input, outDir string, ladder []transcode.Rendition, hasAudio bool) error
+	}{
+		{cfg.hlsEnabled, "hls", transcode.HLSMasterPlaylist, &keys.HLS, transcode.HLS},
+		{cfg.dashEnabled, "dash", transcode.DASHManifest, &keys.DASH, transcode.DASH},
+	}
+	for _, format := range formats {
+		if !format.enabled {
+			continue
+		}
+
+		outDir, err := os.MkdirTemp("", "tubely-"+format.dir+"-*")
+		if err != nil {
+			return keys, err
+		}
+		defer os.RemoveAll(outDir)
+
+		err = format.build(ctx, source, outDir, ladder, hasAudio)
+		if err != nil {
+			return keys, fmt.Errorf("couldn't generate %s: %w", format.dir, err)
+		}
+
+		formatPrefix := prefix + "/" + format.dir
+		err = cfg.uploadDir(ctx, outDir, formatPrefix)
+		if err != nil {
+			return keys, fmt.Errorf("couldn't upload %s package: %w", format.dir, err)
+		}
+		*format.key = formatPrefix + "/" + format.manifest
+	}
+	return keys, nil
+}